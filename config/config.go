@@ -3,24 +3,108 @@ package config
 import (
 	"encoding/json"
 	"fmt"
+	logger "log"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 )
 
+var log *logger.Logger = logger.New(LogOutput, "[Config] ", logger.LstdFlags|logger.Lmsgprefix)
+
 var jsonMu sync.RWMutex
 
+// jsonCache holds the last valid data of the files watched by JSONStore.Watch. It is protected by
+// jsonMu.
+var jsonCache = make(map[string]map[string]json.RawMessage)
+
+// Backups is the number of previous versions JSONSave keeps of a file, named file.1 (newest) to
+// file.N (oldest).
+var Backups = 3
+
 // JSONSave saves a struct in a json file.
 //
 //	file string the file name/path of the json file
 //	key  string the json key for indexing the data. It will override existing data if any
 //	data any    the data to save
 func JSONSave(file, key string, data any) error {
-	fileData := make(map[string]any)
+	return JSONBatch(file, func(tx *Tx) error {
+		return tx.Save(key, data)
+	})
+}
+
+// JSONUpdate loads, changes and saves the data under key in a single step, so concurrent updates
+// of the same file can not get lost. The file stays unchanged if mutate returns an error.
+//
+//	file   string           the file name/path of the json file
+//	key    string           the json key for indexing the data
+//	data   any              a pointer to the data to store in; it is left as is if key does not exist
+//	mutate func(bool) error changes data; its argument reports whether key existed
+func JSONUpdate(file, key string, data any, mutate func(exists bool) error) error {
+	return JSONBatch(file, func(tx *Tx) error {
+		return tx.update(key, data, mutate)
+	})
+}
+
+// JSONBatch runs f with a transaction on file. All changes made through tx are written at once
+// when f returns nil and are dropped otherwise. Other calls on the json files wait meanwhile.
+//
+// A watched file changed by another process is taken over before f runs, as the watcher may not
+// have seen the change yet. If the change is invalid, JSONBatch fails instead of overwriting it.
+func JSONBatch(file string, f func(tx *Tx) error) error {
+	notify, err := jsonBatch(file, f)
+	if notify != nil {
+		notify()
+	}
+	return err
+}
 
+// jsonBatch is JSONBatch without notifying about a change of file taken over, which must be done
+// once jsonMu is released.
+func jsonBatch(file string, f func(tx *Tx) error) (notify func(), err error) {
 	jsonMu.Lock()
 	defer jsonMu.Unlock()
-	if _, err := os.Stat(file); os.IsNotExist(err) {
+	unlock, err := lockFile(file, true)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	if notify, err = freshCache(file); err != nil {
+		return nil, err
+	}
+	return notify, writeBatch(file, f)
+}
+
+// writeBatch runs f and writes its changes. jsonMu and the lock of file must be held.
+func writeBatch(file string, f func(tx *Tx) error) error {
+	data, current, err := readJSON(file)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	tx := &Tx{data: make(map[string]json.RawMessage, len(data))}
+	for k, v := range data {
+		tx.data[k] = v
+	}
+
+	if err = f(tx); err != nil || !tx.changed {
+		return err
+	}
+
+	buf, err := json.MarshalIndent(tx.data, "", "	")
+	if err != nil {
+		return err
+	}
+	if _, cached := jsonCache[file]; cached {
+		// the file may contain an invalid edit, keep the cached data as backup instead
+		current = nil
+		if len(data) > 0 {
+			current, _ = json.MarshalIndent(data, "", "	")
+		}
+		jsonCache[file] = tx.data
+	}
+	if _, err = os.Stat(file); os.IsNotExist(err) {
 		// create dir of file
 		path := strings.Split(file, string(os.PathSeparator))
 		if dir := strings.Join(path[:len(path)-1], string(os.PathSeparator)); dir != "" {
@@ -28,25 +112,247 @@ func JSONSave(file, key string, data any) error {
 				return err
 			}
 		}
-	} else {
-		// read current files
-		buf, err := os.ReadFile(file)
-		if err != nil {
+	} else if current != nil {
+		if err = backup(file, current); err != nil {
 			return err
 		}
-		err = json.Unmarshal(buf, &fileData)
-		if err != nil {
+	}
+	if err = writeFile(file, buf); err != nil {
+		return err
+	}
+	noteWrite(file)
+	return nil
+}
+
+// JSONDelete removes the given keys from a json file. Keys that do not exist are ignored.
+func JSONDelete(file string, keys ...string) error {
+	return JSONBatch(file, func(tx *Tx) error {
+		for _, key := range keys {
+			tx.Delete(key)
+		}
+		return nil
+	})
+}
+
+// Tx gives access to the data of a json file within JSONBatch, or of a collection within
+// Store.Batch.
+type Tx struct {
+	data    map[string]json.RawMessage
+	saved   map[string]bool
+	deleted map[string]bool
+	changed bool
+	// get and keys read from the underlying store, if data only holds the keys used so far. get
+	// returns nil if key does not exist.
+	get  func(key string) json.RawMessage
+	keys func() []string
+}
+
+// lookup returns the data under key, reading it from the underlying store if needed.
+func (tx *Tx) lookup(key string) (json.RawMessage, bool) {
+	if buf, ok := tx.data[key]; ok {
+		return buf, true
+	}
+	if tx.get == nil || tx.deleted[key] {
+		return nil, false
+	}
+	buf := tx.get(key)
+	if buf == nil {
+		return nil, false
+	}
+	tx.data[key] = buf
+	return buf, true
+}
+
+// Has reports whether key exists.
+func (tx *Tx) Has(key string) bool {
+	_, ok := tx.lookup(key)
+	return ok
+}
+
+// Keys returns all keys of the file, without VersionKey.
+func (tx *Tx) Keys() []string {
+	all := make(map[string]bool, len(tx.data))
+	if tx.keys != nil {
+		for _, k := range tx.keys() {
+			all[k] = !tx.deleted[k]
+		}
+	}
+	for k := range tx.data {
+		all[k] = true
+	}
+	keys := make([]string, 0, len(all))
+	for k, ok := range all {
+		if ok && k != VersionKey {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// Load stores the data under key in data.
+func (tx *Tx) Load(key string, data any) error {
+	buf, ok := tx.lookup(key)
+	if !ok {
+		return fmt.Errorf("key '%s' not exists", key)
+	}
+	return json.Unmarshal(buf, data)
+}
+
+// Save sets the data under key, overriding existing data if any.
+func (tx *Tx) Save(key string, data any) error {
+	buf, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	tx.data[key] = buf
+	if tx.saved == nil {
+		tx.saved = make(map[string]bool)
+	}
+	tx.saved[key] = true
+	delete(tx.deleted, key)
+	tx.changed = true
+	return nil
+}
+
+// Delete removes key.
+func (tx *Tx) Delete(key string) {
+	if !tx.Has(key) {
+		return
+	}
+	delete(tx.data, key)
+	delete(tx.saved, key)
+	if tx.deleted == nil {
+		tx.deleted = make(map[string]bool)
+	}
+	tx.deleted[key] = true
+	tx.changed = true
+}
+
+func (tx *Tx) update(key string, data any, mutate func(exists bool) error) error {
+	exists := tx.Has(key)
+	if exists {
+		if err := tx.Load(key, data); err != nil {
 			return err
 		}
 	}
+	if err := mutate(exists); err != nil {
+		return err
+	}
+	return tx.Save(key, data)
+}
+
+// readJSON returns the data of file and its content, if it was read from disk. Watched files are
+// served from jsonCache. jsonMu must be held.
+func readJSON(file string) (data map[string]json.RawMessage, buf []byte, err error) {
+	if cached, ok := jsonCache[file]; ok {
+		return cached, nil, nil
+	}
+	buf, err = os.ReadFile(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	data = make(map[string]json.RawMessage)
+	if err = json.Unmarshal(buf, &data); err != nil {
+		return nil, nil, err
+	}
+	return data, buf, nil
+}
+
+// writeFile atomically replaces file with buf. The data is written to a temporary file in the same
+// dir, synced and renamed over file, so a crash leaves either the old or the new file but never a
+// truncated one.
+func writeFile(file string, buf []byte) error {
+	dir := filepath.Dir(file)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(file)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(buf); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp.Name(), file); err != nil {
+		return err
+	}
 
-	// add data
-	fileData[key] = data
-	buf, err := json.MarshalIndent(fileData, "", "	")
+	// persist the rename
+	d, err := os.Open(dir)
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(file, buf, 0644)
+	defer d.Close()
+	return d.Sync()
+}
+
+// backup rotates the backups of file and saves buf as the newest one.
+func backup(file string, buf []byte) error {
+	if Backups <= 0 {
+		return nil
+	}
+	for i := Backups; i > 1; i-- {
+		err := os.Rename(backupName(file, i-1), backupName(file, i))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return writeFile(backupName(file, 1), buf)
+}
+
+func backupName(file string, i int) string {
+	return file + "." + strconv.Itoa(i)
+}
+
+// Recover checks that file contains valid json and restores it from the newest valid backup
+// otherwise. It returns the name of the restored backup, or an empty string if file was fine or
+// does not exist. Recover is meant to be called at startup, before the file is used.
+func Recover(file string) (restored string, err error) {
+	jsonMu.Lock()
+	defer jsonMu.Unlock()
+	unlock, err := lockFile(file, true)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+	buf, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	if json.Valid(buf) {
+		return "", nil
+	}
+
+	for i := 1; i <= Backups; i++ {
+		buf, err := os.ReadFile(backupName(file, i))
+		if err != nil || !json.Valid(buf) {
+			continue
+		}
+		if err = writeFile(file, buf); err != nil {
+			return "", err
+		}
+		noteWrite(file)
+		if _, cached := jsonCache[file]; cached {
+			data := make(map[string]json.RawMessage)
+			json.Unmarshal(buf, &data)
+			jsonCache[file] = data
+		}
+		return backupName(file, i), nil
+	}
+	return "", fmt.Errorf("'%s' is corrupt and has no valid backup", file)
 }
 
 // JSONLoad loads a struct from a json file and stores it in data.
@@ -57,22 +363,44 @@ func JSONSave(file, key string, data any) error {
 func JSONLoad(file, key string, data any) error {
 	jsonMu.RLock()
 	defer jsonMu.RUnlock()
-	buf, err := os.ReadFile(file)
+	unlock, err := lockFile(file, false)
 	if err != nil {
 		return err
 	}
-
-	fileData := make(map[string]any)
-	if err = json.Unmarshal(buf, &fileData); err != nil {
+	defer unlock()
+	fileData, _, err := readJSON(file)
+	if err != nil {
 		return err
 	}
 
-	fileDataKey, ok := fileData[key]
+	buf, ok := fileData[key]
 	if !ok {
 		return fmt.Errorf("key '%s' not exists", key)
 	}
+	return json.Unmarshal(buf, data)
+}
 
-	buf, _ = json.Marshal(fileDataKey)
+// JSONLoadAll loads all data of a json file into data, which has to point to a map of the saved
+// type. If file does not exist data is left as is and err = nil.
+func JSONLoadAll(file string, data any) error {
+	jsonMu.RLock()
+	defer jsonMu.RUnlock()
+	unlock, err := lockFile(file, false)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	fileData, buf, err := readJSON(file)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if _, ok := fileData[VersionKey]; ok || buf == nil {
+		if buf, err = json.Marshal(withoutVersion(fileData)); err != nil {
+			return err
+		}
+	}
 	return json.Unmarshal(buf, data)
 }
 
@@ -82,21 +410,23 @@ func JSONLoad(file, key string, data any) error {
 func JSONKeys(file string) (keys []string, err error) {
 	jsonMu.RLock()
 	defer jsonMu.RUnlock()
-	buf, err := os.ReadFile(file)
+	unlock, err := lockFile(file, false)
+	if err != nil {
+		return []string{}, err
+	}
+	defer unlock()
+	fileData, _, err := readJSON(file)
 	if os.IsNotExist(err) {
 		return []string{}, nil
 	} else if err != nil {
 		return []string{}, err
 	}
 
-	fileData := make(map[string]any)
-	if err = json.Unmarshal(buf, &fileData); err != nil {
-		return []string{}, err
-	}
-
 	keys = make([]string, 0, len(fileData))
 	for k := range fileData {
-		keys = append(keys, k)
+		if k != VersionKey {
+			keys = append(keys, k)
+		}
 	}
 	return keys, nil
 }
@@ -1,8 +1,16 @@
 package config
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 type testData struct {
@@ -108,3 +116,374 @@ func TestLoad(t *testing.T) {
 		})
 	}
 }
+
+func TestSaveBackups(t *testing.T) {
+	file := "test/backup/test.json"
+	os.RemoveAll("test/backup")
+	for i := 0; i < Backups+2; i++ {
+		if err := JSONSave(file, "count", i); err != nil {
+			t.Fatalf("Save() error = %v", err)
+		}
+	}
+
+	for i := 1; i <= Backups+1; i++ {
+		var count int
+		err := JSONLoad(backupName(file, i), "count", &count)
+		if i > Backups {
+			if !os.IsNotExist(err) {
+				t.Errorf("Save() want no backup %d; got error %v", i, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Load() error = %v", err)
+		}
+		if want := Backups + 1 - i; count != want {
+			t.Errorf("Save() backup %d want: %v; got %v", i, want, count)
+		}
+	}
+
+	entries, err := os.ReadDir("test/backup")
+	if err != nil {
+		t.Fatalf("ReadDir() error = %v", err)
+	}
+	files := 0
+	for _, e := range entries {
+		if !strings.HasSuffix(e.Name(), ".lock") {
+			files++
+		}
+	}
+	if files != Backups+1 {
+		t.Errorf("Save() want %d files; got %d", Backups+1, files)
+	}
+}
+
+func TestRecover(t *testing.T) {
+	file := "test/recover/test.json"
+	os.RemoveAll("test/recover")
+	if restored, err := Recover(file); restored != "" || err != nil {
+		t.Errorf("Recover() of missing file want: \"\", nil; got %v, %v", restored, err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := JSONSave(file, "count", i); err != nil {
+			t.Fatalf("Save() error = %v", err)
+		}
+	}
+	if restored, err := Recover(file); restored != "" || err != nil {
+		t.Errorf("Recover() of valid file want: \"\", nil; got %v, %v", restored, err)
+	}
+
+	// truncate the file and its newest backup
+	os.WriteFile(file, []byte(`{"count": `), 0644)
+	os.WriteFile(backupName(file, 1), []byte(`{"cou`), 0644)
+	restored, err := Recover(file)
+	if err != nil {
+		t.Fatalf("Recover() error = %v", err)
+	}
+	if want := backupName(file, 2); restored != want {
+		t.Errorf("Recover() want: %v; got %v", want, restored)
+	}
+	var count int
+	if err = JSONLoad(file, "count", &count); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if count != 0 {
+		t.Errorf("Recover() want: %v; got %v", 0, count)
+	}
+
+	for i := 1; i <= Backups; i++ {
+		os.Remove(backupName(file, i))
+	}
+	os.WriteFile(file, []byte(`{`), 0644)
+	if _, err = Recover(file); err == nil {
+		t.Errorf("Recover() without backups want error; got nil")
+	}
+}
+
+func TestUpdateConcurrent(t *testing.T) {
+	file := "test/update/test.json"
+	os.RemoveAll("test/update")
+
+	const writers = 20
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// every writer increments a shared counter and adds its own key
+			var count int
+			err := JSONUpdate(file, "counter", &count, func(exists bool) error {
+				count++
+				return nil
+			})
+			if err != nil {
+				t.Errorf("Update() error = %v", err)
+			}
+			if err = JSONSave(file, strconv.Itoa(i), testData{Key2: i}); err != nil {
+				t.Errorf("Save() error = %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	var count int
+	if err := JSONLoad(file, "counter", &count); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if count != writers {
+		t.Errorf("Update() want: %v; got %v", writers, count)
+	}
+	for i := 0; i < writers; i++ {
+		var data testData
+		if err := JSONLoad(file, strconv.Itoa(i), &data); err != nil {
+			t.Errorf("Load() of key %d error = %v", i, err)
+		} else if data.Key2 != i {
+			t.Errorf("Load() of key %d want: %v; got %v", i, i, data.Key2)
+		}
+	}
+}
+
+func TestBatch(t *testing.T) {
+	file := "test/batch/test.json"
+	os.RemoveAll("test/batch")
+	for k, v := range testMap {
+		if err := JSONSave(file, k, v); err != nil {
+			t.Fatalf("Save() error = %v", err)
+		}
+	}
+
+	// a failing batch changes nothing
+	errAbort := errors.New("abort")
+	err := JSONBatch(file, func(tx *Tx) error {
+		tx.Delete("first")
+		tx.Save("new", testData{Key1: "new"})
+		return errAbort
+	})
+	if err != errAbort {
+		t.Errorf("Batch() want: %v; got %v", errAbort, err)
+	}
+	all := make(map[string]testData)
+	if err = JSONLoadAll(file, &all); err != nil {
+		t.Fatalf("LoadAll() error = %v", err)
+	}
+	if len(all) != len(testMap) {
+		t.Errorf("Batch() want %d keys after abort; got %v", len(testMap), all)
+	}
+
+	// move every entry to a new key
+	err = JSONBatch(file, func(tx *Tx) error {
+		for _, k := range tx.Keys() {
+			var data testData
+			if err := tx.Load(k, &data); err != nil {
+				return err
+			}
+			tx.Delete(k)
+			if err := tx.Save("moved-"+k, data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Batch() error = %v", err)
+	}
+	if err = JSONDelete(file, "moved-some", "unknown"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+
+	all = make(map[string]testData)
+	if err = JSONLoadAll(file, &all); err != nil {
+		t.Fatalf("LoadAll() error = %v", err)
+	}
+	for k, v := range testMap {
+		got, ok := all["moved-"+k]
+		if k == "some" {
+			if ok {
+				t.Errorf("Delete() want no key %s; got %v", "moved-"+k, got)
+			}
+			continue
+		}
+		if !ok || got != *v {
+			t.Errorf("LoadAll() key %s want: %v; got %v", "moved-"+k, *v, got)
+		}
+	}
+	if len(all) != len(testMap)-1 {
+		t.Errorf("LoadAll() want %d keys; got %v", len(testMap)-1, all)
+	}
+}
+
+func TestLockFile(t *testing.T) {
+	file := "test/lock/test.json"
+	if err := JSONSave(file, "key", 1); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	// hold the lock like another process would
+	unlock, err := lockFile(file, true)
+	if err != nil {
+		t.Fatalf("lockFile() error = %v", err)
+	}
+	saved := make(chan error)
+	go func() { saved <- JSONSave(file, "key", 2) }()
+	select {
+	case err = <-saved:
+		t.Fatalf("Save() returned while the file was locked: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	unlock()
+	if err = <-saved; err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	// reading takes no lock and creates no lock file, as nobody changed the file
+	written := "test/lock/written.json"
+	if err = os.WriteFile(written, []byte(`{"key": 1}`), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	var v int
+	if err = JSONLoad(written, "key", &v); err != nil || v != 1 {
+		t.Fatalf("Load() want: 1; got %v, error = %v", v, err)
+	}
+	if _, err = os.Stat(written + ".lock"); !os.IsNotExist(err) {
+		t.Errorf("Load() created the lock file: %v", err)
+	}
+}
+
+func TestChangedExternally(t *testing.T) {
+	file := "test/external/test.json"
+	os.RemoveAll("test/external")
+	if err := JSONSave(file, "key", 1); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	if changed, err := changedExternally(file); changed || err != nil {
+		t.Errorf("changedExternally() after Save() want: false, nil; got %v, %v", changed, err)
+	}
+
+	if err := os.WriteFile(file, []byte(`{"key": 2, "other": 3}`), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if changed, err := changedExternally(file); !changed || err != nil {
+		t.Errorf("changedExternally() after external write want: true, nil; got %v, %v", changed, err)
+	}
+	if changed, _ := changedExternally(file); changed {
+		t.Errorf("changedExternally() on second check want: false; got true")
+	}
+}
+
+func TestWatch(t *testing.T) {
+	s := JSONStore{Dir: t.TempDir()}
+	if err := s.Save("test", "key", 1); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	defer func(d time.Duration) { watchDelay = d }(watchDelay)
+	watchDelay = 10 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reloads := make(chan map[string]json.RawMessage, 10)
+	validate := func(collection string, data map[string]json.RawMessage) error {
+		if _, ok := data["key"]; !ok {
+			return errors.New("missing key")
+		}
+		return nil
+	}
+	reloaded := func(collection string, old, data map[string]json.RawMessage) {
+		reloads <- data
+	}
+	if err := s.Watch(ctx, []string{"test"}, validate, reloaded); err != nil {
+		t.Fatalf("Watch() error = %v", err)
+	}
+	load := func() (n int) {
+		if err := s.Load("test", "key", &n); err != nil {
+			t.Fatalf("Load() error = %v", err)
+		}
+		return n
+	}
+
+	// own writes are not reloaded
+	if err := s.Save("test", "key", 2); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	select {
+	case data := <-reloads:
+		t.Errorf("Watch() reloaded own write: %s", data)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// invalid changes are ignored
+	for _, content := range []string{`{"key": 3`, `{"other": 3}`} {
+		os.WriteFile(s.File("test"), []byte(content), 0644)
+		select {
+		case data := <-reloads:
+			t.Errorf("Watch() reloaded invalid change %s: %s", content, data)
+		case <-time.After(100 * time.Millisecond):
+		}
+		if n := load(); n != 2 {
+			t.Errorf("Load() after invalid change want: %v; got %v", 2, n)
+		}
+	}
+
+	os.WriteFile(s.File("test"), []byte(`{"key": 4}`), 0644)
+	select {
+	case <-reloads:
+	case <-time.After(time.Second):
+		t.Fatalf("Watch() did not reload valid change")
+	}
+	if n := load(); n != 4 {
+		t.Errorf("Load() after valid change want: %v; got %v", 4, n)
+	}
+}
+
+func TestSaveAfterExternalChange(t *testing.T) {
+	s := JSONStore{Dir: t.TempDir()}
+	if err := s.Save("test", "key", 1); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reloads := make(chan map[string]json.RawMessage, 10)
+	validate := func(collection string, data map[string]json.RawMessage) error {
+		if _, ok := data["key"]; !ok {
+			return errors.New("missing key")
+		}
+		return nil
+	}
+	reloaded := func(collection string, old, data map[string]json.RawMessage) {
+		reloads <- data
+	}
+	if err := s.Watch(ctx, []string{"test"}, validate, reloaded); err != nil {
+		t.Fatalf("Watch() error = %v", err)
+	}
+
+	// saved before the watcher sees the change, which must be kept
+	os.WriteFile(s.File("test"), []byte(`{"key": 2, "external": 3}`), 0644)
+	if err := s.Save("test", "own", 4); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	select {
+	case data := <-reloads:
+		if string(data["external"]) != "3" {
+			t.Errorf("reloaded want: external change; got %s", data)
+		}
+	default:
+		t.Errorf("Save() did not reload the external change")
+	}
+	all := make(map[string]int)
+	if err := s.LoadAll("test", &all); err != nil {
+		t.Fatalf("LoadAll() error = %v", err)
+	}
+	if want := map[string]int{"key": 2, "external": 3, "own": 4}; !reflect.DeepEqual(all, want) {
+		t.Errorf("LoadAll() want: %v; got %v", want, all)
+	}
+
+	// an invalid change is neither taken over nor overwritten
+	invalid := []byte(`{"other": 5}`)
+	os.WriteFile(s.File("test"), invalid, 0644)
+	if err := s.Save("test", "own", 6); err == nil {
+		t.Errorf("Save() after invalid external change want error")
+	}
+	if buf, _ := os.ReadFile(s.File("test")); string(buf) != string(invalid) {
+		t.Errorf("file want: %s; got %s", invalid, buf)
+	}
+}
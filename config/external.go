@@ -0,0 +1,260 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// fileStamp identifies a version of a file.
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+var (
+	stampsMu sync.Mutex
+	// stamps are the versions of the json files as last written or seen by this process
+	stamps = make(map[string]fileStamp)
+)
+
+// watchedFile is a file kept in memory by Watch, with the callbacks to take over changes.
+type watchedFile struct {
+	collection string
+	validate   func(collection string, data map[string]json.RawMessage) error
+	reloaded   func(collection string, old, data map[string]json.RawMessage)
+}
+
+// watched are the files in jsonCache, protected by jsonMu.
+var watched = make(map[string]watchedFile)
+
+func statStamp(file string) fileStamp {
+	fi, err := os.Stat(file)
+	if err != nil {
+		return fileStamp{}
+	}
+	return fileStamp{fi.ModTime(), fi.Size()}
+}
+
+// noteWrite remembers the current version of file as written by this process. The lock of file
+// must be held.
+func noteWrite(file string) {
+	stampsMu.Lock()
+	stamps[file] = statStamp(file)
+	stampsMu.Unlock()
+}
+
+// changedExternally reports whether file was changed by another process since it was last written
+// or checked by this process.
+func changedExternally(file string) (bool, error) {
+	unlock, err := lockFile(file, false)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	current := statStamp(file)
+	stampsMu.Lock()
+	defer stampsMu.Unlock()
+	last, ok := stamps[file]
+	stamps[file] = current
+	return ok && current != last, nil
+}
+
+// watchDelay is how long Watch waits for further changes of a file before reading it, as editors
+// may write a file in several steps.
+var watchDelay = 250 * time.Millisecond
+
+// Watch keeps the files of the given collections in memory and reloads them when they are changed
+// by another process, like the import command or an editor. A changed collection is only used if
+// it is valid json and validate returns nil; otherwise the change is logged and the last valid data
+// stays in use. After a change was taken over reloaded is called with the previous and the new
+// data. Both callbacks get the data without VersionKey. Watch returns once the watcher is set up
+// and stops watching when ctx is done.
+func (s JSONStore) Watch(ctx context.Context, collections []string,
+	validate func(collection string, data map[string]json.RawMessage) error,
+	reloaded func(collection string, old, data map[string]json.RawMessage)) error {
+	if err := os.MkdirAll(s.Dir, 0777); err != nil {
+		return err
+	}
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	if err = w.Add(s.Dir); err != nil {
+		w.Close()
+		return err
+	}
+
+	files := make(map[string]string, len(collections))
+	jsonMu.Lock()
+	for _, c := range collections {
+		file := s.File(c)
+		files[file] = c
+		data, _, err := readJSON(file)
+		if os.IsNotExist(err) {
+			data = make(map[string]json.RawMessage)
+		} else if err != nil {
+			log.Printf("ERROR: could not keep %s in memory: %+v", file, err)
+			continue
+		}
+		jsonCache[file] = data
+		watched[file] = watchedFile{c, validate, reloaded}
+	}
+	jsonMu.Unlock()
+	// remember the current versions
+	for file := range files {
+		changedExternally(file)
+	}
+
+	go func() {
+		defer w.Close()
+		changed := make(chan string)
+		timers := make(map[string]*time.Timer)
+		for {
+			select {
+			case <-ctx.Done():
+				for _, t := range timers {
+					t.Stop()
+				}
+				return
+			case err := <-w.Errors:
+				log.Printf("ERROR: watching %s failed: %+v", s.Dir, err)
+			case e := <-w.Events:
+				file := filepath.Join(s.Dir, filepath.Base(e.Name))
+				if _, ok := files[file]; !ok {
+					continue
+				}
+				if t, ok := timers[file]; ok {
+					t.Reset(watchDelay)
+				} else {
+					timers[file] = time.AfterFunc(watchDelay, func() {
+						select {
+						case changed <- file:
+						case <-ctx.Done():
+						}
+					})
+				}
+			case file := <-changed:
+				s.reload(files[file], validate, reloaded)
+			}
+		}
+	}()
+	return nil
+}
+
+// Refresh reloads the files of the given watched collections right away, if they were changed by
+// another process, instead of waiting for the watcher. It returns the reasons changes were ignored.
+func (s JSONStore) Refresh(collections []string,
+	validate func(collection string, data map[string]json.RawMessage) error,
+	reloaded func(collection string, old, data map[string]json.RawMessage)) error {
+	var errs []error
+	for _, c := range collections {
+		jsonMu.Lock()
+		_, cached := jsonCache[s.File(c)]
+		jsonMu.Unlock()
+		if !cached {
+			// read from disk on every use anyway
+			continue
+		}
+		if err := s.reload(c, validate, reloaded); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// reload takes over the file of collection, if it was changed by another process and is valid. It
+// returns why a change was ignored.
+func (s JSONStore) reload(collection string,
+	validate func(collection string, data map[string]json.RawMessage) error,
+	reloaded func(collection string, old, data map[string]json.RawMessage)) error {
+	file := s.File(collection)
+	// hold jsonMu from checking the version until the cache is updated, so no batch writes the
+	// stale cache meanwhile
+	jsonMu.Lock()
+	if changed, err := changedExternally(file); err != nil || !changed {
+		jsonMu.Unlock()
+		return err
+	}
+
+	unlock, err := lockFile(file, false)
+	if err != nil {
+		jsonMu.Unlock()
+		log.Printf("ERROR: could not lock %s: %+v", file, err)
+		return err
+	}
+	buf, err := os.ReadFile(file)
+	unlock()
+	data := make(map[string]json.RawMessage)
+	if err == nil {
+		err = json.Unmarshal(buf, &data)
+	}
+	if err == nil {
+		err = validate(collection, withoutVersion(data))
+	}
+	if err != nil {
+		jsonMu.Unlock()
+		log.Printf("ERROR: ignoring change of %s: %+v", file, err)
+		return fmt.Errorf("ignoring change of %s: %v", file, err)
+	}
+
+	old, ok := jsonCache[file]
+	if !ok {
+		// the file is not kept in memory, as it was invalid when Watch was called
+		old = make(map[string]json.RawMessage)
+	}
+	jsonCache[file] = data
+	watched[file] = watchedFile{collection, validate, reloaded}
+	jsonMu.Unlock()
+	log.Printf("Reloaded %s", file)
+	reloaded(collection, withoutVersion(old), withoutVersion(data))
+	return nil
+}
+
+// freshCache takes over a change of the watched file made by another process, which the watcher
+// may not have seen yet, so a batch never writes from stale cached data. A change that is not valid
+// json or fails validation is an error. freshCache returns the function calling reloaded for the
+// change, which must be called once jsonMu is released. jsonMu and the lock of file must be held.
+func freshCache(file string) (notify func(), err error) {
+	w, ok := watched[file]
+	if !ok {
+		return nil, nil
+	}
+	current := statStamp(file)
+	stampsMu.Lock()
+	last, known := stamps[file]
+	stampsMu.Unlock()
+	if !known || current == last {
+		return nil, nil
+	}
+
+	data := make(map[string]json.RawMessage)
+	buf, err := os.ReadFile(file)
+	if err == nil {
+		err = json.Unmarshal(buf, &data)
+	} else if os.IsNotExist(err) {
+		err = nil
+	}
+	if err == nil {
+		err = w.validate(w.collection, withoutVersion(data))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s was changed by another process and is invalid: %v", file, err)
+	}
+
+	stampsMu.Lock()
+	stamps[file] = current
+	stampsMu.Unlock()
+	old := jsonCache[file]
+	jsonCache[file] = data
+	log.Printf("Reloaded %s", file)
+	return func() { w.reloaded(w.collection, withoutVersion(old), withoutVersion(data)) }, nil
+}
@@ -0,0 +1,8 @@
+//go:build !unix || aix
+
+package config
+
+// lockFile is a no-op on systems without flock.
+func lockFile(file string, exclusive bool) (unlock func(), err error) {
+	return func() {}, nil
+}
@@ -0,0 +1,41 @@
+//go:build unix && !aix
+
+package config
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// lockFile takes an advisory flock on file.lock, so other processes using the same convention,
+// like a second homeserver or "flock config/lights.json.lock vi config/lights.json", do not change
+// file meanwhile. The lock is released by calling unlock. Only exclusive locks create the lock
+// file; without one nobody changed file yet, so shared locks are not taken. If the lock file can
+// not be created, because the dir of file does not exist yet, no lock is taken either.
+func lockFile(file string, exclusive bool) (unlock func(), err error) {
+	flags, how := os.O_RDONLY, unix.LOCK_SH
+	if exclusive {
+		flags, how = os.O_RDONLY|os.O_CREATE, unix.LOCK_EX
+	}
+	f, err := os.OpenFile(file+".lock", flags, 0644)
+	if os.IsNotExist(err) {
+		return func() {}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	for {
+		err = unix.Flock(int(f.Fd()), how)
+		if err != unix.EINTR {
+			break
+		}
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return func() {
+		unix.Flock(int(f.Fd()), unix.LOCK_UN)
+		f.Close()
+	}, nil
+}
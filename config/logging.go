@@ -0,0 +1,88 @@
+package config
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+// log levels, see SetLogLevel
+const (
+	levelDebug int32 = iota
+	levelInfo
+	levelError
+)
+
+var logLevel atomic.Int32
+
+func init() {
+	logLevel.Store(levelInfo)
+}
+
+// LogOutput is the writer of all loggers. It drops the lines below the log level and writes the
+// others to the log file set by SetLogFile.
+var LogOutput io.Writer = levelWriter{}
+
+var (
+	outputMu sync.Mutex
+	output   io.Writer = os.Stderr
+	logFile  *os.File
+)
+
+// SetLogFile appends the log to file, or writes it to stderr if file is empty. Setting the same file
+// again reopens it, e.g. after it was rotated. The previous file is kept if file can't be opened.
+func SetLogFile(file string) error {
+	var f *os.File
+	if file != "" {
+		var err error
+		if f, err = os.OpenFile(file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644); err != nil {
+			return err
+		}
+	}
+
+	outputMu.Lock()
+	defer outputMu.Unlock()
+	if logFile != nil {
+		logFile.Close()
+	}
+	logFile = f
+	if f != nil {
+		output = f
+	} else {
+		output = os.Stderr
+	}
+	return nil
+}
+
+// SetLogLevel sets which lines are written to LogOutput: "error" only writes lines containing
+// "ERROR" or "Error", "info" all lines but those containing "DEBUG" and "debug" all lines.
+func SetLogLevel(level string) {
+	switch level {
+	case "debug":
+		logLevel.Store(levelDebug)
+	case "error":
+		logLevel.Store(levelError)
+	default:
+		logLevel.Store(levelInfo)
+	}
+}
+
+type levelWriter struct{}
+
+func (lw levelWriter) Write(p []byte) (int, error) {
+	level := levelInfo
+	switch {
+	case bytes.Contains(p, []byte("ERROR")) || bytes.Contains(p, []byte("Error")):
+		level = levelError
+	case bytes.Contains(p, []byte("DEBUG")):
+		level = levelDebug
+	}
+	if level < logLevel.Load() {
+		return len(p), nil
+	}
+	outputMu.Lock()
+	defer outputMu.Unlock()
+	return output.Write(p)
+}
@@ -0,0 +1,151 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// VersionKey is the reserved key holding the schema version inside a collection, so the version
+// travels with the data, e.g. into a backup or another store. Keys and LoadAll of every store, as
+// well as Tx.Keys, leave it out.
+const VersionKey = "$version"
+
+// Migration upgrades the data of a collection by one version. The changes of all pending
+// migrations are written together with the new version, so a migration runs only once.
+type Migration func(tx *Tx) error
+
+var (
+	migrationsMu sync.Mutex
+	migrations   = make(map[string]map[int]Migration)
+)
+
+// RegisterMigration adds the migration of collection to the given version, which upgrades data of
+// version-1. Versions start at 1; data saved before the first migration has version 0. It panics
+// if a migration for the version is already registered.
+func RegisterMigration(collection string, version int, m Migration) {
+	migrationsMu.Lock()
+	defer migrationsMu.Unlock()
+	if version < 1 {
+		panic(fmt.Sprintf("invalid migration version %d of '%s'", version, collection))
+	}
+	if migrations[collection] == nil {
+		migrations[collection] = make(map[int]Migration)
+	}
+	if _, ok := migrations[collection][version]; ok {
+		panic(fmt.Sprintf("migration %d of '%s' is already registered", version, collection))
+	}
+	migrations[collection][version] = m
+}
+
+// LatestVersion returns the version collection has after all registered migrations.
+func LatestVersion(collection string) int {
+	migrationsMu.Lock()
+	defer migrationsMu.Unlock()
+	latest := 0
+	for v := range migrations[collection] {
+		latest = max(latest, v)
+	}
+	return latest
+}
+
+// SchemaVersion returns the version of the data of collection in s.
+func SchemaVersion(s Store, collection string) (version int, err error) {
+	err = s.Batch(collection, func(tx *Tx) error {
+		if !tx.Has(VersionKey) {
+			return nil
+		}
+		return tx.Load(VersionKey, &version)
+	})
+	return version, err
+}
+
+// withoutVersion returns data without VersionKey. data itself is left as is.
+func withoutVersion(data map[string]json.RawMessage) map[string]json.RawMessage {
+	if _, ok := data[VersionKey]; !ok {
+		return data
+	}
+	visible := make(map[string]json.RawMessage, len(data)-1)
+	for k, v := range data {
+		if k != VersionKey {
+			visible[k] = v
+		}
+	}
+	return visible
+}
+
+// RunMigrations upgrades every collection in s with registered migrations to its latest version.
+// Before a collection is changed, its data is copied to the collection "<collection>.v<version>".
+// Empty collections are new and set to the latest version right away.
+func RunMigrations(s Store) error {
+	migrationsMu.Lock()
+	collections := make([]string, 0, len(migrations))
+	for c := range migrations {
+		collections = append(collections, c)
+	}
+	migrationsMu.Unlock()
+	sort.Strings(collections)
+
+	for _, c := range collections {
+		if err := migrate(s, c); err != nil {
+			return fmt.Errorf("could not migrate '%s': %v", c, err)
+		}
+	}
+	return nil
+}
+
+func migrate(s Store, collection string) error {
+	latest := LatestVersion(collection)
+	version, err := SchemaVersion(s, collection)
+	if err != nil || version >= latest {
+		return err
+	}
+	keys, err := s.Keys(collection)
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return s.Save(collection, VersionKey, latest)
+	}
+
+	// backup
+	data := make(map[string]json.RawMessage)
+	if err = s.LoadAll(collection, &data); err != nil {
+		return err
+	}
+	backup := collection + ".v" + strconv.Itoa(version)
+	err = s.Batch(backup, func(tx *Tx) error {
+		for k, v := range data {
+			if err := tx.Save(k, v); err != nil {
+				return err
+			}
+		}
+		return tx.Save(VersionKey, version)
+	})
+	if err != nil {
+		return fmt.Errorf("could not save backup: %v", err)
+	}
+
+	migrationsMu.Lock()
+	pending := migrations[collection]
+	migrationsMu.Unlock()
+	err = s.Batch(collection, func(tx *Tx) error {
+		for v := version + 1; v <= latest; v++ {
+			m, ok := pending[v]
+			if !ok {
+				return fmt.Errorf("missing migration to version %d", v)
+			}
+			if err := m(tx); err != nil {
+				return fmt.Errorf("migration to version %d failed: %v", v, err)
+			}
+		}
+		return tx.Save(VersionKey, latest)
+	})
+	if err != nil {
+		return err
+	}
+	log.Printf("Migrated %s from version %d to %d, the old data is kept in %s", collection, version, latest, backup)
+	return nil
+}
@@ -0,0 +1,69 @@
+package config
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRunMigrations(t *testing.T) {
+	defer func(m map[string]map[int]Migration) { migrations = m }(migrations)
+	migrations = make(map[string]map[int]Migration)
+
+	var order []int
+	step := func(v int) Migration {
+		return func(tx *Tx) error {
+			order = append(order, v)
+			var n int
+			tx.Load("n", &n)
+			return tx.Save("n", n*10+v)
+		}
+	}
+	RegisterMigration("test", 2, step(2))
+	RegisterMigration("test", 1, step(1))
+	RegisterMigration("test", 3, step(3))
+
+	// new collections start at the latest version
+	s := NewMemoryStore()
+	if err := RunMigrations(s); err != nil {
+		t.Fatalf("RunMigrations() error = %v", err)
+	}
+	if v, _ := SchemaVersion(s, "test"); v != 3 || len(order) != 0 {
+		t.Errorf("RunMigrations() of empty collection want version 3 without migrations; got %v, %v", v, order)
+	}
+
+	s = NewMemoryStore()
+	s.Save("test", "n", 0)
+	s.Save("test", VersionKey, 1)
+	if err := RunMigrations(s); err != nil {
+		t.Fatalf("RunMigrations() error = %v", err)
+	}
+	var n int
+	s.Load("test", "n", &n)
+	if n != 23 || len(order) != 2 {
+		t.Errorf("RunMigrations() want: 23 after [2 3]; got %v after %v", n, order)
+	}
+	if err := s.Load("test.v1", "n", &n); err != nil || n != 0 {
+		t.Errorf("RunMigrations() backup want: 0; got %v, %v", n, err)
+	}
+	if v, _ := SchemaVersion(s, "test.v1"); v != 1 {
+		t.Errorf("SchemaVersion() of backup want: 1; got %v", v)
+	}
+	if keys, _ := s.Keys("test"); len(keys) != 1 || keys[0] != "n" {
+		t.Errorf("Keys() after RunMigrations() want: [n]; got %v", keys)
+	}
+
+	// a failing migration changes nothing
+	RegisterMigration("test", 4, func(tx *Tx) error {
+		tx.Delete("n")
+		return errors.New("failed")
+	})
+	if err := RunMigrations(s); err == nil {
+		t.Errorf("RunMigrations() want error; got nil")
+	}
+	if v, _ := SchemaVersion(s, "test"); v != 3 {
+		t.Errorf("SchemaVersion() after failed migration want: 3; got %v", v)
+	}
+	if err := s.Load("test", "n", &n); err != nil || n != 23 {
+		t.Errorf("RunMigrations() after failed migration want: 23; got %v, %v", n, err)
+	}
+}
@@ -1,40 +1,133 @@
 package config
 
-import "sync"
+import (
+	"reflect"
+	"sync"
+	"time"
+)
 
-var strConf map[string]string
-var intConf map[string]int
-var confMu sync.RWMutex
+var (
+	confMu   sync.RWMutex
+	conf     = make(map[string]any)
+	watchers = make(map[string]map[*watcher]bool)
+)
 
-func init() {
-	strConf = make(map[string]string)
-	intConf = make(map[string]int)
+type watcher struct {
+	notify func(v any)
+	stop   func()
 }
 
-// SetString saves a global string variable to use anywhere with GetString(k)
-func SetString(k, v string) {
+// Set saves a global variable to use anywhere with Get[T](k). Watchers of k are notified if the
+// value changed.
+func Set[T any](k string, v T) {
+	confMu.Lock()
+	defer confMu.Unlock()
+	old, ok := conf[k]
+	conf[k] = v
+	if ok && reflect.DeepEqual(old, v) {
+		return
+	}
+	// notifying does not block, so it is done under the lock to keep the order of changes
+	for w := range watchers[k] {
+		w.notify(v)
+	}
+}
+
+// Get returns a variable previously saved with Set(k, v). ok is false if k was never set or holds
+// a value of another type.
+func Get[T any](k string) (v T, ok bool) {
+	confMu.RLock()
+	defer confMu.RUnlock()
+	v, ok = conf[k].(T)
+	return v, ok
+}
+
+// Watch returns a channel receiving the new value of k whenever it changes to a value of type T.
+// Only the latest value is kept for slow receivers. The returned function must be called to stop
+// watching; it closes the channel.
+func Watch[T any](k string) (<-chan T, func()) {
+	ch := make(chan T, 1)
+	var mu sync.Mutex
+	closed := false
+	w := &watcher{}
+	w.notify = func(v any) {
+		t, ok := v.(T)
+		if !ok {
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if closed {
+			return
+		}
+		// replace a value not received yet
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- t
+	}
+	w.stop = func() {
+		confMu.Lock()
+		delete(watchers[k], w)
+		confMu.Unlock()
+		mu.Lock()
+		defer mu.Unlock()
+		if !closed {
+			closed = true
+			close(ch)
+		}
+	}
+
 	confMu.Lock()
-	strConf[k] = v
+	if watchers[k] == nil {
+		watchers[k] = make(map[*watcher]bool)
+	}
+	watchers[k][w] = true
 	confMu.Unlock()
+	return ch, w.stop
+}
+
+// SetString saves a global string variable to use anywhere with GetString(k)
+func SetString(k, v string) {
+	Set(k, v)
 }
 
 // GetString returns a previously saved string variable with SetString(k, v)
 func GetString(k string) (v string) {
-	confMu.Lock()
-	confMu.Unlock()
-	return strConf[k]
+	v, _ = Get[string](k)
+	return v
 }
 
 // SetInt saves a global integer variable to use anywhere with GetInt(k)
 func SetInt(k string, v int) {
-	confMu.Lock()
-	intConf[k] = v
-	confMu.Unlock()
+	Set(k, v)
 }
 
-// GetString returns a previously saved integer variable with SetInt(k, v)
+// GetInt returns a previously saved integer variable with SetInt(k, v)
 func GetInt(k string) (v int) {
-	confMu.Lock()
-	defer confMu.Unlock()
-	return intConf[k]
+	v, _ = Get[int](k)
+	return v
+}
+
+// SetBool saves a global boolean variable to use anywhere with GetBool(k)
+func SetBool(k string, v bool) {
+	Set(k, v)
+}
+
+// GetBool returns a previously saved boolean variable with SetBool(k, v)
+func GetBool(k string) (v bool) {
+	v, _ = Get[bool](k)
+	return v
+}
+
+// SetDuration saves a global duration variable to use anywhere with GetDuration(k)
+func SetDuration(k string, v time.Duration) {
+	Set(k, v)
+}
+
+// GetDuration returns a previously saved duration variable with SetDuration(k, v)
+func GetDuration(k string) (v time.Duration) {
+	v, _ = Get[time.Duration](k)
+	return v
 }
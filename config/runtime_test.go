@@ -0,0 +1,68 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGetSet(t *testing.T) {
+	type point struct{ X, Y int }
+
+	SetString("test.string", "value")
+	SetInt("test.int", 42)
+	SetBool("test.bool", true)
+	SetDuration("test.duration", time.Minute)
+	Set("test.struct", point{1, 2})
+
+	if v, ok := Get[string]("test.string"); !ok || v != "value" {
+		t.Errorf("Get[string]() want: value, true; got %v, %v", v, ok)
+	}
+	if v := GetInt("test.int"); v != 42 {
+		t.Errorf("GetInt() want: 42; got %v", v)
+	}
+	if v := GetBool("test.bool"); !v {
+		t.Errorf("GetBool() want: true; got %v", v)
+	}
+	if v := GetDuration("test.duration"); v != time.Minute {
+		t.Errorf("GetDuration() want: %v; got %v", time.Minute, v)
+	}
+	if v, ok := Get[point]("test.struct"); !ok || v != (point{1, 2}) {
+		t.Errorf("Get[point]() want: {1 2}, true; got %v, %v", v, ok)
+	}
+
+	if v, ok := Get[int]("test.missing"); ok || v != 0 {
+		t.Errorf("Get[int]() of missing key want: 0, false; got %v, %v", v, ok)
+	}
+	if v, ok := Get[int]("test.string"); ok || v != 0 {
+		t.Errorf("Get[int]() of string want: 0, false; got %v, %v", v, ok)
+	}
+}
+
+func TestWatchKey(t *testing.T) {
+	ch, stop := Watch[int]("test.watch")
+	SetInt("test.watch", 1)
+	if v := <-ch; v != 1 {
+		t.Errorf("Watch() want: 1; got %v", v)
+	}
+
+	// unchanged values and other types are not sent, only the latest value is kept
+	SetInt("test.watch", 1)
+	SetString("test.watch", "x")
+	SetInt("test.watch", 2)
+	SetInt("test.watch", 3)
+	if v := <-ch; v != 3 {
+		t.Errorf("Watch() want: 3; got %v", v)
+	}
+	select {
+	case v := <-ch:
+		t.Errorf("Watch() want no further value; got %v", v)
+	default:
+	}
+
+	stop()
+	SetInt("test.watch", 4)
+	if v, ok := <-ch; ok {
+		t.Errorf("Watch() after stop want closed channel; got %v", v)
+	}
+	stop()
+}
@@ -0,0 +1,282 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"golang.org/x/exp/slices"
+)
+
+// DefaultSettingsFile is read if no config file is given by the -config flag or HOMESERVER_CONFIG.
+const DefaultSettingsFile = "homeserver.toml"
+
+// Settings is the static configuration of the homeserver. Every setting is read from the config
+// file, the environment variable HOMESERVER_<NAME> and the flag -<name>, in increasing order of
+// precedence. Nested names like "features.ssdp" become HOMESERVER_FEATURES_SSDP and
+// -features.ssdp.
+type Settings struct {
+	Listen          string   `toml:"listen"`
+	Port            int      `toml:"port"`
+	Interface       string   `toml:"interface"`
+	Subnet          string   `toml:"subnet"`
+	AdvertiseIP     string   `toml:"advertise_ip"`
+	NetworkInterval int      `toml:"network_interval"`
+	DataDir         string   `toml:"data_dir"`
+	Store           string   `toml:"store"`
+	BridgeName      string   `toml:"bridge_name"`
+	SSDPInterval    int      `toml:"ssdp_interval"`
+	SSDPMaxAge      int      `toml:"ssdp_max_age"`
+	LogLevel        string   `toml:"log_level"`
+	LogFile         string   `toml:"log_file"`
+	Validation      string   `toml:"validation"`
+	HealthInterval  int      `toml:"health_interval"`
+	DeviceRate      int      `toml:"device_rate"`
+	RetryMaxAge     int      `toml:"retry_max_age"`
+	Features        Features `toml:"features"`
+}
+
+// Features are the optional parts of the homeserver, which can be turned off.
+type Features struct {
+	SSDP    bool `toml:"ssdp"`
+	MDNS    bool `toml:"mdns"`
+	Plugins bool `toml:"plugins"`
+	Sync    bool `toml:"sync"`
+	Health  bool `toml:"health"`
+	Retries bool `toml:"retries"`
+	Watch   bool `toml:"watch"`
+}
+
+// DefaultSettings returns the settings used for everything not configured.
+func DefaultSettings() Settings {
+	return Settings{
+		Port:            80,
+		NetworkInterval: 30,
+		DataDir:         "config",
+		BridgeName:      "GOlexa",
+		SSDPInterval:    60,
+		SSDPMaxAge:      100,
+		LogLevel:        "info",
+		Validation:      "lenient",
+		HealthInterval:  15,
+		DeviceRate:      10,
+		RetryMaxAge:     600,
+		Features:        Features{SSDP: true, MDNS: true, Plugins: true, Sync: true, Health: true, Retries: true, Watch: true},
+	}
+}
+
+// option is a single setting as it is named in the environment and the flags.
+type option struct {
+	name  string
+	value any
+	usage string
+}
+
+func (s *Settings) options() []option {
+	return []option{
+		{"listen", &s.Listen, "the ip to listen on, empty for all"},
+		{"port", &s.Port, "the port of the webserver"},
+		{"interface", &s.Interface, "the network interface the bridge is advertised on, empty for the first with a private ipv4 address"},
+		{"subnet", &s.Subnet, "only advertise an address within this cidr, e.g. \"192.168.1.0/24\""},
+		{"advertise_ip", &s.AdvertiseIP, "the ip the bridge is advertised with, detected if empty"},
+		{"network_interval", &s.NetworkInterval, "seconds between checks for a changed ip, 0 to never check"},
+		{"data_dir", &s.DataDir, "the dir of the json data files"},
+		{"store", &s.Store, "the data store, e.g. \"bolt:config/homeserver.db\"; defaults to the json files in data_dir"},
+		{"bridge_name", &s.BridgeName, "the name the bridge is discovered with"},
+		{"ssdp_interval", &s.SSDPInterval, "seconds between ssdp alive notifications"},
+		{"ssdp_max_age", &s.SSDPMaxAge, "seconds an ssdp announcement is valid"},
+		{"log_level", &s.LogLevel, "\"debug\", \"info\" or \"error\""},
+		{"log_file", &s.LogFile, "the file to append the log to, empty for stderr; reopened on SIGHUP"},
+		{"validation", &s.Validation, "\"strict\" refuses to start with invalid lights, \"lenient\" skips them"},
+		{"health_interval", &s.HealthInterval, "seconds between reachability checks of the lights"},
+		{"device_rate", &s.DeviceRate, "how often a light is sent a new state per second at most"},
+		{"retry_max_age", &s.RetryMaxAge, "seconds after which failed device commands are given up"},
+		{"features.ssdp", &s.Features.SSDP, "advertise the bridge by ssdp"},
+		{"features.mdns", &s.Features.MDNS, "advertise the bridge by mdns as _hue._tcp service"},
+		{"features.plugins", &s.Features.Plugins, "start the external driver plugins"},
+		{"features.sync", &s.Features.Sync, "read back changes made on the devices"},
+		{"features.health", &s.Features.Health, "check the reachability of the lights"},
+		{"features.retries", &s.Features.Retries, "retry failed device commands"},
+		{"features.watch", &s.Features.Watch, "reload data files changed by other processes"},
+	}
+}
+
+func (o option) envName() string {
+	return "HOMESERVER_" + strings.ToUpper(strings.ReplaceAll(o.name, ".", "_"))
+}
+
+func (o option) flagName() string {
+	return strings.ReplaceAll(o.name, "_", "-")
+}
+
+// Set implements flag.Value.
+func (o option) Set(v string) error {
+	switch p := o.value.(type) {
+	case *string:
+		*p = v
+	case *int:
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("'%s' is not a number", v)
+		}
+		*p = n
+	case *bool:
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("'%s' is not a boolean", v)
+		}
+		*p = b
+	}
+	return nil
+}
+
+// String implements flag.Value.
+func (o option) String() string {
+	if o.value == nil {
+		return ""
+	}
+	switch p := o.value.(type) {
+	case *string:
+		return *p
+	case *int:
+		return strconv.Itoa(*p)
+	case *bool:
+		return strconv.FormatBool(*p)
+	}
+	return ""
+}
+
+// IsBoolFlag allows boolean flags without value, like -features.ssdp.
+func (o option) IsBoolFlag() bool {
+	_, ok := o.value.(*bool)
+	return ok
+}
+
+// LoadSettings reads the settings from the config file, the environment and the flags in args. It
+// returns the arguments left after the flags, e.g. a command.
+func LoadSettings(args []string) (s Settings, rest []string, err error) {
+	s = DefaultSettings()
+
+	// the config file has to be read before the other flags are applied
+	file, explicit := os.LookupEnv("HOMESERVER_CONFIG")
+	if !explicit {
+		file = DefaultSettingsFile
+	}
+	for i, arg := range args {
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if !strings.HasPrefix(arg, "-") || name != "config" {
+			continue
+		}
+		if !hasValue && i+1 < len(args) {
+			value = args[i+1]
+		}
+		file, explicit = value, true
+	}
+	md, err := toml.DecodeFile(file, &s)
+	if errors.Is(err, os.ErrNotExist) && !explicit {
+		err = nil
+	} else if err != nil {
+		return s, nil, fmt.Errorf("could not read %s: %v", file, err)
+	} else if undecoded := md.Undecoded(); len(undecoded) > 0 {
+		return s, nil, fmt.Errorf("unknown setting '%s' in %s", undecoded[0], file)
+	}
+
+	options := s.options()
+	for _, o := range options {
+		if v, ok := os.LookupEnv(o.envName()); ok {
+			if err = o.Set(v); err != nil {
+				return s, nil, fmt.Errorf("invalid %s: %v", o.envName(), err)
+			}
+		}
+	}
+
+	fs := flag.NewFlagSet("homeserver", flag.ContinueOnError)
+	fs.String("config", file, "the config file")
+	for _, o := range options {
+		fs.Var(o, o.flagName(), o.usage)
+	}
+	if err = fs.Parse(args); err != nil {
+		return s, nil, err
+	}
+	return s, fs.Args(), s.validate()
+}
+
+func (s Settings) validate() error {
+	switch {
+	case s.Port < 1 || s.Port > 65535:
+		return fmt.Errorf("port %d out of range", s.Port)
+	case !slices.Contains([]string{"debug", "info", "error"}, s.LogLevel):
+		return fmt.Errorf("unknown log level '%s'", s.LogLevel)
+	case s.Validation != "strict" && s.Validation != "lenient":
+		return fmt.Errorf("unknown validation mode '%s'", s.Validation)
+	case s.DeviceRate <= 0:
+		return fmt.Errorf("device rate must be positive")
+	case s.SSDPInterval <= 0 || s.SSDPMaxAge <= 0:
+		return fmt.Errorf("ssdp interval and max age must be positive")
+	case s.NetworkInterval < 0:
+		return fmt.Errorf("network interval must not be negative")
+	case s.AdvertiseIP != "" && net.ParseIP(s.AdvertiseIP) == nil:
+		return fmt.Errorf("invalid advertise ip '%s'", s.AdvertiseIP)
+	}
+	if s.Subnet != "" {
+		if _, _, err := net.ParseCIDR(s.Subnet); err != nil {
+			return fmt.Errorf("invalid subnet '%s'", s.Subnet)
+		}
+	}
+	return nil
+}
+
+// StoreSpec returns the store to open with OpenStore.
+func (s Settings) StoreSpec() string {
+	if s.Store != "" {
+		return s.Store
+	}
+	return "json:" + s.DataDir
+}
+
+// Diff returns the names of the settings that differ from other, e.g. "port" or "features.ssdp".
+func (s Settings) Diff(other Settings) []string {
+	var names []string
+	theirs := other.options()
+	for i, o := range s.options() {
+		if o.String() != theirs[i].String() {
+			names = append(names, o.name)
+		}
+	}
+	return names
+}
+
+// Apply makes the settings available through GetString, GetInt and GetBool, under the camel cased
+// names of the settings, e.g. "healthInterval" or "features.ssdp".
+func (s Settings) Apply() {
+	SetString("listen", s.Listen)
+	SetInt("port", s.Port)
+	SetString("interface", s.Interface)
+	SetString("subnet", s.Subnet)
+	SetString("advertiseIP", s.AdvertiseIP)
+	SetInt("networkInterval", s.NetworkInterval)
+	SetString("dataDir", s.DataDir)
+	SetString("store", s.StoreSpec())
+	SetString("bridgeName", s.BridgeName)
+	SetInt("ssdpInterval", s.SSDPInterval)
+	SetInt("ssdpMaxAge", s.SSDPMaxAge)
+	SetString("logLevel", s.LogLevel)
+	SetString("logFile", s.LogFile)
+	SetString("validation", s.Validation)
+	SetInt("healthInterval", s.HealthInterval)
+	SetInt("deviceRate", s.DeviceRate)
+	SetInt("retryMaxAge", s.RetryMaxAge)
+	SetBool("features.ssdp", s.Features.SSDP)
+	SetBool("features.mdns", s.Features.MDNS)
+	SetBool("features.plugins", s.Features.Plugins)
+	SetBool("features.sync", s.Features.Sync)
+	SetBool("features.health", s.Features.Health)
+	SetBool("features.retries", s.Features.Retries)
+	SetBool("features.watch", s.Features.Watch)
+	SetLogLevel(s.LogLevel)
+}
@@ -0,0 +1,98 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadSettings(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "homeserver.toml")
+	os.WriteFile(file, []byte(`
+port = 8080
+bridge_name = "From File"
+log_level = "error"
+
+[features]
+ssdp = false
+`), 0644)
+
+	t.Setenv("HOMESERVER_CONFIG", file)
+	t.Setenv("HOMESERVER_PORT", "8081")
+	t.Setenv("HOMESERVER_DATA_DIR", "/var/lib/homeserver")
+	t.Setenv("HOMESERVER_FEATURES_PLUGINS", "false")
+
+	s, rest, err := LoadSettings([]string{"-port", "8082", "-features.sync=false", "-interface=eth0", "import", "hue", "-x"})
+	if err != nil {
+		t.Fatalf("LoadSettings() error = %v", err)
+	}
+	want := DefaultSettings()
+	want.Port = 8082
+	want.BridgeName = "From File"
+	want.LogLevel = "error"
+	want.DataDir = "/var/lib/homeserver"
+	want.Interface = "eth0"
+	want.Features.SSDP = false
+	want.Features.Plugins = false
+	want.Features.Sync = false
+	if s != want {
+		t.Errorf("LoadSettings() want: %+v; got %+v", want, s)
+	}
+	if len(rest) != 3 || rest[0] != "import" || rest[2] != "-x" {
+		t.Errorf("LoadSettings() rest want: [import hue -x]; got %v", rest)
+	}
+	if spec := s.StoreSpec(); spec != "json:/var/lib/homeserver" {
+		t.Errorf("StoreSpec() want: %v; got %v", "json:/var/lib/homeserver", spec)
+	}
+}
+
+func TestLoadSettingsErrors(t *testing.T) {
+	dir := t.TempDir()
+	unknown := filepath.Join(dir, "unknown.toml")
+	os.WriteFile(unknown, []byte("prot = 80\n"), 0644)
+	// an empty config file, so a homeserver.toml in the working dir is not read
+	empty := filepath.Join(dir, "empty.toml")
+	os.WriteFile(empty, nil, 0644)
+	t.Setenv("HOMESERVER_CONFIG", empty)
+	if _, _, err := LoadSettings(nil); err != nil {
+		t.Fatalf("LoadSettings() with empty config file error = %v", err)
+	}
+
+	tests := []struct {
+		name string
+		env  map[string]string
+		args []string
+	}{
+		{"missing explicit file", nil, []string{"-config", filepath.Join(dir, "missing.toml")}},
+		{"unknown setting", nil, []string{"-config=" + unknown}},
+		{"invalid env", map[string]string{"HOMESERVER_PORT": "eighty"}, nil},
+		{"invalid flag", nil, []string{"-features.ssdp=maybe"}},
+		{"out of range", nil, []string{"-port", "70000"}},
+		{"unknown log level", map[string]string{"HOMESERVER_LOG_LEVEL": "verbose"}, nil},
+		{"invalid subnet", nil, []string{"-subnet", "192.168.1.0"}},
+		{"invalid advertise ip", map[string]string{"HOMESERVER_ADVERTISE_IP": "192.168.1"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			if _, _, err := LoadSettings(tt.args); err == nil {
+				t.Errorf("LoadSettings() want error; got nil")
+			}
+		})
+	}
+}
+
+func TestSettingsDiff(t *testing.T) {
+	s := DefaultSettings()
+	other := s
+	if diff := s.Diff(other); len(diff) != 0 {
+		t.Errorf("Diff() of equal settings want: []; got %v", diff)
+	}
+	other.Port = 8080
+	other.Features.SSDP = false
+	if diff := s.Diff(other); len(diff) != 2 || diff[0] != "port" || diff[1] != "features.ssdp" {
+		t.Errorf("Diff() want: [port features.ssdp]; got %v", diff)
+	}
+}
@@ -0,0 +1,469 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Store saves json encodable data by key in named collections, like "users" or "lights".
+type Store interface {
+	// Load stores the data under key in data.
+	Load(collection, key string, data any) error
+	// LoadAll stores all data of the collection in data, which has to point to a map. The schema
+	// version under VersionKey is left out.
+	LoadAll(collection string, data any) error
+	// Keys returns all keys of the collection, without VersionKey.
+	Keys(collection string) ([]string, error)
+	// Save sets the data under key, overriding existing data if any.
+	Save(collection, key string, data any) error
+	// Batch runs f with a transaction on the collection. The changes are only written if f
+	// returns nil.
+	Batch(collection string, f func(tx *Tx) error) error
+	// Delete removes the given keys from the collection.
+	Delete(collection string, keys ...string) error
+	// Collections returns the names of all collections.
+	Collections() ([]string, error)
+	// Close releases the resources of the store.
+	Close() error
+}
+
+var (
+	storeMu sync.RWMutex
+	store   Store = JSONStore{Dir: "config"}
+)
+
+// SetStore replaces the store used by the package level functions, which is a JSONStore in the
+// "config" dir by default. It returns the previous store.
+func SetStore(s Store) Store {
+	storeMu.Lock()
+	defer storeMu.Unlock()
+	prev := store
+	store = s
+	return prev
+}
+
+// GetStore returns the store used by the package level functions.
+func GetStore() Store {
+	storeMu.RLock()
+	defer storeMu.RUnlock()
+	return store
+}
+
+// OpenStore opens the store described by spec:
+//
+//	json:<dir>   one json file per collection in dir
+//	bolt:<file>  a bbolt database with one bucket per collection
+//	memory       in memory only, e.g. for tests
+func OpenStore(spec string) (Store, error) {
+	kind, path, _ := strings.Cut(spec, ":")
+	switch kind {
+	case "json":
+		if path == "" {
+			path = "config"
+		}
+		return JSONStore{Dir: path}, nil
+	case "bolt":
+		if path == "" {
+			return nil, fmt.Errorf("missing file of bolt store")
+		}
+		return OpenBoltStore(path)
+	case "memory":
+		return NewMemoryStore(), nil
+	}
+	return nil, fmt.Errorf("unknown store '%s'", spec)
+}
+
+// Migrate copies all collections of src to dst, including their schema versions. Existing keys in
+// dst are overridden.
+func Migrate(dst, src Store) error {
+	collections, err := src.Collections()
+	if err != nil {
+		return err
+	}
+	for _, c := range collections {
+		data := make(map[string]json.RawMessage)
+		if err = src.LoadAll(c, &data); err != nil {
+			return fmt.Errorf("could not load '%s': %v", c, err)
+		}
+		version, err := SchemaVersion(src, c)
+		if err != nil {
+			return fmt.Errorf("could not load version of '%s': %v", c, err)
+		}
+		err = dst.Batch(c, func(tx *Tx) error {
+			for k, v := range data {
+				if err := tx.Save(k, v); err != nil {
+					return err
+				}
+			}
+			if version > 0 {
+				return tx.Save(VersionKey, version)
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("could not save '%s': %v", c, err)
+		}
+	}
+	return nil
+}
+
+// Load stores the data under key of the collection in data.
+func Load(collection, key string, data any) error {
+	return GetStore().Load(collection, key, data)
+}
+
+// LoadAll stores all data of the collection in data, which has to point to a map.
+func LoadAll(collection string, data any) error {
+	return GetStore().LoadAll(collection, data)
+}
+
+// Keys returns all keys of the collection.
+func Keys(collection string) ([]string, error) {
+	return GetStore().Keys(collection)
+}
+
+// Save sets the data under key of the collection.
+func Save(collection, key string, data any) error {
+	return GetStore().Save(collection, key, data)
+}
+
+// Batch runs f with a transaction on the collection.
+func Batch(collection string, f func(tx *Tx) error) error {
+	return GetStore().Batch(collection, f)
+}
+
+// Delete removes the given keys from the collection.
+func Delete(collection string, keys ...string) error {
+	return GetStore().Delete(collection, keys...)
+}
+
+// Update loads, changes and saves the data under key of the collection in a single step. See
+// JSONUpdate.
+func Update(collection, key string, data any, mutate func(exists bool) error) error {
+	return Batch(collection, func(tx *Tx) error {
+		return tx.update(key, data, mutate)
+	})
+}
+
+// JSONStore keeps every collection in the json file <Dir>/<collection>.json.
+type JSONStore struct {
+	Dir string
+}
+
+// File returns the path of the file of collection.
+func (s JSONStore) File(collection string) string {
+	return filepath.Join(s.Dir, collection+".json")
+}
+
+func (s JSONStore) Load(collection, key string, data any) error {
+	return JSONLoad(s.File(collection), key, data)
+}
+
+func (s JSONStore) LoadAll(collection string, data any) error {
+	return JSONLoadAll(s.File(collection), data)
+}
+
+func (s JSONStore) Keys(collection string) ([]string, error) {
+	return JSONKeys(s.File(collection))
+}
+
+func (s JSONStore) Save(collection, key string, data any) error {
+	return JSONSave(s.File(collection), key, data)
+}
+
+func (s JSONStore) Batch(collection string, f func(tx *Tx) error) error {
+	return JSONBatch(s.File(collection), f)
+}
+
+func (s JSONStore) Delete(collection string, keys ...string) error {
+	return JSONDelete(s.File(collection), keys...)
+}
+
+// Collections returns the names of all json files in Dir. Files with further dots in their name,
+// like backups or examples, are skipped.
+func (s JSONStore) Collections() ([]string, error) {
+	entries, err := os.ReadDir(s.Dir)
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	collections := []string{}
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), ".json")
+		if ok && !e.IsDir() && name != "" && !strings.Contains(name, ".") {
+			collections = append(collections, name)
+		}
+	}
+	return collections, nil
+}
+
+// Recover restores the file of collection from a backup if it is corrupt. See Recover.
+func (s JSONStore) Recover(collection string) (restored string, err error) {
+	return Recover(s.File(collection))
+}
+
+func (JSONStore) Close() error {
+	return nil
+}
+
+// MemoryStore keeps all collections in memory.
+type MemoryStore struct {
+	mu          sync.RWMutex
+	collections map[string]map[string]json.RawMessage
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{collections: make(map[string]map[string]json.RawMessage)}
+}
+
+func (s *MemoryStore) Load(collection, key string, data any) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	buf, ok := s.collections[collection][key]
+	if !ok {
+		return fmt.Errorf("key '%s' not exists", key)
+	}
+	return json.Unmarshal(buf, data)
+}
+
+func (s *MemoryStore) LoadAll(collection string, data any) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	c, ok := s.collections[collection]
+	if !ok {
+		return nil
+	}
+	buf, err := json.Marshal(withoutVersion(c))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(buf, data)
+}
+
+func (s *MemoryStore) Keys(collection string) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	keys := make([]string, 0, len(s.collections[collection]))
+	for k := range s.collections[collection] {
+		if k != VersionKey {
+			keys = append(keys, k)
+		}
+	}
+	return keys, nil
+}
+
+func (s *MemoryStore) Save(collection, key string, data any) error {
+	return s.Batch(collection, func(tx *Tx) error {
+		return tx.Save(key, data)
+	})
+}
+
+func (s *MemoryStore) Batch(collection string, f func(tx *Tx) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tx := &Tx{data: make(map[string]json.RawMessage, len(s.collections[collection]))}
+	for k, v := range s.collections[collection] {
+		tx.data[k] = v
+	}
+	if err := f(tx); err != nil || !tx.changed {
+		return err
+	}
+	s.collections[collection] = tx.data
+	return nil
+}
+
+func (s *MemoryStore) Delete(collection string, keys ...string) error {
+	return s.Batch(collection, func(tx *Tx) error {
+		for _, key := range keys {
+			tx.Delete(key)
+		}
+		return nil
+	})
+}
+
+func (s *MemoryStore) Collections() ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	collections := make([]string, 0, len(s.collections))
+	for c := range s.collections {
+		collections = append(collections, c)
+	}
+	sort.Strings(collections)
+	return collections, nil
+}
+
+func (*MemoryStore) Close() error {
+	return nil
+}
+
+// BoltStore keeps every collection in a bucket of a bbolt database. It scales better than the
+// JSONStore to many lights, as a change does not rewrite the whole collection.
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// OpenBoltStore opens or creates the bbolt database at file.
+func OpenBoltStore(file string) (*BoltStore, error) {
+	if dir := filepath.Dir(file); dir != "" {
+		if err := os.MkdirAll(dir, 0777); err != nil {
+			return nil, err
+		}
+	}
+	db, err := bolt.Open(file, 0644, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	return &BoltStore{db: db}, nil
+}
+
+func (s *BoltStore) Load(collection, key string, data any) error {
+	return s.db.View(func(btx *bolt.Tx) error {
+		var buf []byte
+		if b := btx.Bucket([]byte(collection)); b != nil {
+			buf = b.Get([]byte(key))
+		}
+		if buf == nil {
+			return fmt.Errorf("key '%s' not exists", key)
+		}
+		return json.Unmarshal(buf, data)
+	})
+}
+
+func (s *BoltStore) LoadAll(collection string, data any) error {
+	all := make(map[string]json.RawMessage)
+	err := s.db.View(func(btx *bolt.Tx) error {
+		b := btx.Bucket([]byte(collection))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			if string(k) != VersionKey {
+				all[string(k)] = append(json.RawMessage(nil), v...)
+			}
+			return nil
+		})
+	})
+	if err != nil || len(all) == 0 {
+		return err
+	}
+	buf, err := json.Marshal(all)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(buf, data)
+}
+
+func (s *BoltStore) Keys(collection string) ([]string, error) {
+	keys := []string{}
+	err := s.db.View(func(btx *bolt.Tx) error {
+		b := btx.Bucket([]byte(collection))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, _ []byte) error {
+			if string(k) != VersionKey {
+				keys = append(keys, string(k))
+			}
+			return nil
+		})
+	})
+	return keys, err
+}
+
+func (s *BoltStore) Save(collection, key string, data any) error {
+	buf, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(btx *bolt.Tx) error {
+		b, err := btx.CreateBucketIfNotExists([]byte(collection))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(key), buf)
+	})
+}
+
+// Batch runs f in a single bbolt transaction. Only the keys used by f are read from the bucket and
+// only the keys changed by f are written.
+func (s *BoltStore) Batch(collection string, f func(tx *Tx) error) error {
+	return s.db.Update(func(btx *bolt.Tx) error {
+		b, err := btx.CreateBucketIfNotExists([]byte(collection))
+		if err != nil {
+			return err
+		}
+		tx := &Tx{
+			data: make(map[string]json.RawMessage),
+			get: func(key string) json.RawMessage {
+				if v := b.Get([]byte(key)); v != nil {
+					// v is only valid during the bbolt transaction
+					return append(json.RawMessage(nil), v...)
+				}
+				return nil
+			},
+			keys: func() []string {
+				var keys []string
+				b.ForEach(func(k, _ []byte) error {
+					keys = append(keys, string(k))
+					return nil
+				})
+				return keys
+			},
+		}
+		if err = f(tx); err != nil || !tx.changed {
+			return err
+		}
+
+		for k := range tx.deleted {
+			if err = b.Delete([]byte(k)); err != nil {
+				return err
+			}
+		}
+		for k := range tx.saved {
+			if err = b.Put([]byte(k), tx.data[k]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *BoltStore) Delete(collection string, keys ...string) error {
+	return s.db.Update(func(btx *bolt.Tx) error {
+		b := btx.Bucket([]byte(collection))
+		if b == nil {
+			return nil
+		}
+		for _, key := range keys {
+			if err := b.Delete([]byte(key)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *BoltStore) Collections() ([]string, error) {
+	collections := []string{}
+	err := s.db.View(func(btx *bolt.Tx) error {
+		return btx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+			collections = append(collections, string(name))
+			return nil
+		})
+	})
+	return collections, err
+}
+
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,145 @@
+package config
+
+import (
+	"errors"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func testStores(t *testing.T) map[string]Store {
+	bolt, err := OpenBoltStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("OpenBoltStore() error = %v", err)
+	}
+	t.Cleanup(func() { bolt.Close() })
+	return map[string]Store{
+		"json":   JSONStore{Dir: t.TempDir()},
+		"memory": NewMemoryStore(),
+		"bolt":   bolt,
+	}
+}
+
+func TestStore(t *testing.T) {
+	for name, s := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			for k, v := range testMap {
+				if err := s.Save("test", k, v); err != nil {
+					t.Fatalf("Save() error = %v", err)
+				}
+			}
+			for k, v := range testMap {
+				var data testData
+				if err := s.Load("test", k, &data); err != nil {
+					t.Fatalf("Load() error = %v", err)
+				}
+				if data != *v {
+					t.Errorf("Load() want: %v; got %v", *v, data)
+				}
+			}
+			if err := s.Load("test", "unknown", &testData{}); err == nil {
+				t.Errorf("Load() of unknown key want error; got nil")
+			}
+			// the version is kept like any key, but left out by Keys and LoadAll
+			if err := s.Save("test", VersionKey, 3); err != nil {
+				t.Fatalf("Save() of version error = %v", err)
+			}
+			if v, err := SchemaVersion(s, "test"); err != nil || v != 3 {
+				t.Errorf("SchemaVersion() want: 3; got %v, %v", v, err)
+			}
+
+			err := s.Batch("test", func(tx *Tx) error {
+				tx.Delete("first")
+				return tx.Save("new", testData{Key1: "new"})
+			})
+			if err != nil {
+				t.Fatalf("Batch() error = %v", err)
+			}
+			if err = s.Delete("test", "some"); err != nil {
+				t.Fatalf("Delete() error = %v", err)
+			}
+
+			keys, err := s.Keys("test")
+			if err != nil {
+				t.Fatalf("Keys() error = %v", err)
+			}
+			sort.Strings(keys)
+			if want := []string{"new", "second"}; len(keys) != 2 || keys[0] != want[0] || keys[1] != want[1] {
+				t.Errorf("Keys() want: %v; got %v", want, keys)
+			}
+
+			all := make(map[string]testData)
+			if err = s.LoadAll("test", &all); err != nil {
+				t.Fatalf("LoadAll() error = %v", err)
+			}
+			if len(all) != 2 || all["new"].Key1 != "new" || all["second"] != *testMap["second"] {
+				t.Errorf("LoadAll() got %v", all)
+			}
+
+			// a failing batch sees its own changes, but writes nothing
+			rollback := errors.New("rollback")
+			err = s.Batch("test", func(tx *Tx) error {
+				tx.Delete("second")
+				tx.Save("third", testData{Key1: "third"})
+				if tx.Has("second") || !tx.Has("new") {
+					t.Errorf("Has() within Batch() want: second deleted, new kept")
+				}
+				keys := tx.Keys()
+				sort.Strings(keys)
+				if len(keys) != 2 || keys[0] != "new" || keys[1] != "third" {
+					t.Errorf("Keys() within Batch() want: [new third]; got %v", keys)
+				}
+				return rollback
+			})
+			if err != rollback {
+				t.Errorf("Batch() want error %v; got %v", rollback, err)
+			}
+			if keys, _ = s.Keys("test"); len(keys) != 2 {
+				t.Errorf("Keys() after failed Batch() want: [new second]; got %v", keys)
+			}
+
+			if keys, err = s.Keys("empty"); err != nil || len(keys) != 0 {
+				t.Errorf("Keys() of missing collection want: [], nil; got %v, %v", keys, err)
+			}
+		})
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	stores := testStores(t)
+	src := stores["json"]
+	for k, v := range testMap {
+		if err := src.Save("test", k, v); err != nil {
+			t.Fatalf("Save() error = %v", err)
+		}
+		if err := src.Save("other", k, v.Key2); err != nil {
+			t.Fatalf("Save() error = %v", err)
+		}
+	}
+	src.Save("test", VersionKey, 2)
+
+	for _, name := range []string{"memory", "bolt"} {
+		dst := stores[name]
+		if err := Migrate(dst, src); err != nil {
+			t.Fatalf("Migrate() to %s error = %v", name, err)
+		}
+		collections, _ := dst.Collections()
+		sort.Strings(collections)
+		if len(collections) != 2 || collections[0] != "other" || collections[1] != "test" {
+			t.Errorf("Migrate() to %s want collections [other test]; got %v", name, collections)
+		}
+		if v, err := SchemaVersion(dst, "test"); err != nil || v != 2 {
+			t.Errorf("Migrate() to %s want version 2; got %v, %v", name, v, err)
+		}
+		for k, v := range testMap {
+			var data testData
+			if err := dst.Load("test", k, &data); err != nil || data != *v {
+				t.Errorf("Migrate() to %s want: %v; got %v, %v", name, *v, data, err)
+			}
+			var n int
+			if err := dst.Load("other", k, &n); err != nil || n != v.Key2 {
+				t.Errorf("Migrate() to %s want: %v; got %v, %v", name, v.Key2, n, err)
+			}
+		}
+	}
+}
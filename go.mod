@@ -3,12 +3,14 @@ module homeserver
 go 1.21
 
 require (
+	github.com/BurntSushi/toml v1.3.2
+	github.com/fsnotify/fsnotify v1.7.0
 	github.com/gorilla/mux v1.8.0
-	github.com/koron/go-ssdp v0.0.3
+	go.etcd.io/bbolt v1.3.8
 	golang.org/x/net v0.5.0
 )
 
 require (
 	golang.org/x/exp v0.0.0-20231006140011-7918f672742d
-	golang.org/x/sys v0.13.0 // indirect
+	golang.org/x/sys v0.13.0
 )
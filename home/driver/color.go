@@ -0,0 +1,162 @@
+package driver
+
+import "math"
+
+// RGB returns the color of s at full brightness. The color is taken from the field selected by
+// s.ColorMode. Lights without a color mode are white.
+func (s State) RGB() (r, g, b uint8) {
+	switch s.ColorMode {
+	case "hs":
+		return HSToRGB(s.Hue, s.Saturation)
+	case "xy":
+		return XYToRGB(s.XY[0], s.XY[1])
+	case "ct":
+		return CTToRGB(s.ColorTemperature)
+	}
+	return 255, 255, 255
+}
+
+// HSToRGB converts a hue (0-65535) and saturation (0-254) to an rgb color at full brightness.
+func HSToRGB(hue, sat int) (r, g, b uint8) {
+	h := float64(hue) / 65536 * 6
+	s := clamp(float64(sat)/254, 0, 1)
+
+	i := math.Floor(h)
+	f := h - i
+	p := 1 - s
+	q := 1 - s*f
+	t := 1 - s*(1-f)
+
+	var rf, gf, bf float64
+	switch int(i) % 6 {
+	case 0:
+		rf, gf, bf = 1, t, p
+	case 1:
+		rf, gf, bf = q, 1, p
+	case 2:
+		rf, gf, bf = p, 1, t
+	case 3:
+		rf, gf, bf = p, q, 1
+	case 4:
+		rf, gf, bf = t, p, 1
+	default:
+		rf, gf, bf = 1, p, q
+	}
+	return toByte(rf), toByte(gf), toByte(bf)
+}
+
+// RGBToHS converts an rgb color to a hue (0-65535) and saturation (0-254). The brightness of the
+// color is ignored.
+func RGBToHS(r, g, b uint8) (hue, sat int) {
+	rf, gf, bf := float64(r)/255, float64(g)/255, float64(b)/255
+	max := math.Max(rf, math.Max(gf, bf))
+	min := math.Min(rf, math.Min(gf, bf))
+	d := max - min
+	if max == 0 || d == 0 {
+		return 0, 0
+	}
+
+	var h float64
+	switch max {
+	case rf:
+		h = math.Mod((gf-bf)/d, 6)
+	case gf:
+		h = (bf-rf)/d + 2
+	default:
+		h = (rf-gf)/d + 4
+	}
+	if h < 0 {
+		h += 6
+	}
+	return int(math.Round(h / 6 * 65535)), int(math.Round(d / max * 254))
+}
+
+// XYToRGB converts a CIE xy color to an rgb color at full brightness using the wide gamut
+// conversion of the hue lights.
+func XYToRGB(x, y float32) (r, g, b uint8) {
+	if y <= 0 {
+		return 255, 255, 255
+	}
+	X := float64(x) / float64(y)
+	Y := 1.0
+	Z := (1 - float64(x) - float64(y)) / float64(y)
+
+	rf := X*1.656492 - Y*0.354851 - Z*0.255038
+	gf := -X*0.707196 + Y*1.655397 + Z*0.036152
+	bf := X*0.051713 - Y*0.121364 + Z*1.011530
+
+	if max := math.Max(rf, math.Max(gf, bf)); max > 1 {
+		rf, gf, bf = rf/max, gf/max, bf/max
+	}
+	return toByte(gamma(rf)), toByte(gamma(gf)), toByte(gamma(bf))
+}
+
+// CTToRGB converts a color temperature in mired to an rgb color at full brightness.
+func CTToRGB(mired int) (r, g, b uint8) {
+	if mired <= 0 {
+		return 255, 255, 255
+	}
+	t := 1e6 / float64(mired) / 100
+
+	var rf, gf, bf float64
+	if t <= 66 {
+		rf = 255
+		gf = 99.4708025861*math.Log(t) - 161.1195681661
+	} else {
+		rf = 329.698727446 * math.Pow(t-60, -0.1332047592)
+		gf = 288.1221695283 * math.Pow(t-60, -0.0755148492)
+	}
+	switch {
+	case t >= 66:
+		bf = 255
+	case t <= 19:
+		bf = 0
+	default:
+		bf = 138.5177312231*math.Log(t-10) - 305.0447927307
+	}
+	return toByte(rf / 255), toByte(gf / 255), toByte(bf / 255)
+}
+
+// MiredToKelvin converts a color temperature from mired to kelvin.
+func MiredToKelvin(mired int) int {
+	if mired <= 0 {
+		return 0
+	}
+	return int(math.Round(1e6 / float64(mired)))
+}
+
+// KelvinToMired converts a color temperature from kelvin to mired.
+func KelvinToMired(kelvin int) int {
+	if kelvin <= 0 {
+		return 0
+	}
+	return int(math.Round(1e6 / float64(kelvin)))
+}
+
+// ScaleBrightness converts the hue brightness (1-254) to a device brightness in the range 0-max.
+func ScaleBrightness(bri, max int) int {
+	return int(math.Round(clamp(float64(bri), 0, 254) * float64(max) / 254))
+}
+
+// UnscaleBrightness converts a device brightness in the range 0-max to the hue brightness (1-254).
+func UnscaleBrightness(v, max int) int {
+	if max <= 0 {
+		return 1
+	}
+	return int(clamp(math.Round(float64(v)*254/float64(max)), 1, 254))
+}
+
+func gamma(v float64) float64 {
+	if v <= 0.0031308 {
+		return 12.92 * v
+	}
+	return 1.055*math.Pow(v, 1/2.4) - 0.055
+}
+
+func toByte(v float64) uint8 {
+	return uint8(math.Round(clamp(v, 0, 1) * 255))
+}
+
+func clamp(v, min, max float64) float64 {
+	return math.Max(min, math.Min(max, v))
+}
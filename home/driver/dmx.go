@@ -0,0 +1,366 @@
+package driver
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+func init() {
+	Register("dmx", newDMX)
+}
+
+const (
+	artNetPort = 6454
+	sACNPort   = 5568
+)
+
+// dmxConfig are the settings of the "dmx" driver.
+//
+//	protocol string "artnet" or "sacn"
+//	address  string the receiving node; defaults to broadcast for Art-Net and multicast for sACN
+//	universe int    the universe of the fixture
+//	channel  int    the first channel (1-512) of the fixture
+//	layout   string the channels of the fixture: "dimmer", "rgb", "rgbw" or "cct" (warm, cold)
+//	16bit    bool   use two channels (coarse, fine) per value
+//	rate     int    how often the universe is sent per second. Defaults to 30; all fixtures of a
+//	                universe must use the same rate
+type dmxConfig struct {
+	Protocol string `json:"protocol"`
+	Address  string `json:"address,omitempty"`
+	Universe int    `json:"universe"`
+	Channel  int    `json:"channel"`
+	Layout   string `json:"layout"`
+	Wide     bool   `json:"16bit,omitempty"`
+	Rate     int    `json:"rate,omitempty"`
+}
+
+// dmx drives a fixture at some channels of an Art-Net or sACN universe.
+type dmx struct {
+	dmxConfig
+	universe *dmxUniverse
+
+	mu     sync.Mutex
+	state  *State
+	closed bool
+}
+
+func newDMX(c Config) (Driver, error) {
+	d := &dmx{dmxConfig: dmxConfig{Rate: 30}}
+	if err := c.Decode(&d.dmxConfig); err != nil {
+		return nil, err
+	}
+
+	width := 1
+	if d.Wide {
+		width = 2
+	}
+	channels := map[string]int{"dimmer": 1, "rgb": 3, "rgbw": 4, "cct": 2}[d.Layout] * width
+	switch {
+	case d.Protocol != "artnet" && d.Protocol != "sacn":
+		return nil, fmt.Errorf("dmx: unknown protocol '%s'", d.Protocol)
+	case channels == 0:
+		return nil, fmt.Errorf("dmx: unknown layout '%s'", d.Layout)
+	case d.Channel < 1 || d.Channel+channels-1 > 512:
+		return nil, fmt.Errorf("dmx: channels %d-%d out of range", d.Channel, d.Channel+channels-1)
+	case d.Protocol == "artnet" && (d.Universe < 0 || d.Universe > 0x7fff):
+		return nil, fmt.Errorf("dmx: universe %d out of range", d.Universe)
+	case d.Protocol == "sacn" && (d.Universe < 1 || d.Universe > 63999):
+		return nil, fmt.Errorf("dmx: universe %d out of range", d.Universe)
+	case d.Rate <= 0:
+		return nil, fmt.Errorf("dmx: rate must be positive")
+	}
+
+	var err error
+	if d.universe, err = getDMXUniverse(d.dmxConfig); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func (d *dmx) SetState(ctx context.Context, s State) error {
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return fmt.Errorf("dmx: fixture is closed")
+	}
+	d.state = &s
+	d.mu.Unlock()
+
+	values := dmxValues(d.Layout, s)
+	width := 1
+	if d.Wide {
+		width = 2
+	}
+	buf := make([]byte, len(values)*width)
+	for i, v := range values {
+		if d.Wide {
+			binary.BigEndian.PutUint16(buf[i*2:], uint16(math.Round(v*0xffff)))
+		} else {
+			buf[i] = uint8(math.Round(v * 0xff))
+		}
+	}
+	return d.universe.set(d.Channel, buf)
+}
+
+// Close releases the universe of the fixture. The universe stops sending once all its fixtures are
+// closed.
+func (d *dmx) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return nil
+	}
+	d.closed = true
+	return d.universe.release()
+}
+
+// State returns the last state sent, as DMX fixtures can not report their state.
+func (d *dmx) State(ctx context.Context) (State, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.state == nil {
+		return State{}, ErrNoState
+	}
+	return *d.state, nil
+}
+
+// dmxValues returns the channel values (0-1) of a fixture with the given layout showing s.
+func dmxValues(layout string, s State) []float64 {
+	level := 0.0
+	if s.On {
+		level = 1
+		if s.Brightness > 0 {
+			level = float64(s.Brightness) / 254
+		}
+	}
+
+	r8, g8, b8 := s.RGB()
+	r, g, b := float64(r8)/255, float64(g8)/255, float64(b8)/255
+	switch layout {
+	case "dimmer":
+		return []float64{level}
+	case "rgb":
+		return []float64{r * level, g * level, b * level}
+	case "rgbw":
+		w := math.Min(r, math.Min(g, b))
+		return []float64{(r - w) * level, (g - w) * level, (b - w) * level, w * level}
+	case "cct":
+		warm := 0.5
+		if s.ColorMode == "ct" {
+			warm = clamp(float64(s.ColorTemperature-153)/(500-153), 0, 1)
+		}
+		return []float64{warm * level, (1 - warm) * level}
+	}
+	return nil
+}
+
+// dmxUniverse holds the channel data of a universe and sends it periodically.
+type dmxUniverse struct {
+	dmxConfig
+	key  string
+	conn *net.UDPConn
+	cid  [16]byte
+	// fixtures is the number of drivers using the universe, protected by dmxUniversesMu
+	fixtures int
+	stop     chan struct{}
+	done     chan struct{}
+
+	mu       sync.Mutex
+	data     [512]byte
+	length   int
+	sequence uint8
+	changed  chan struct{}
+}
+
+var (
+	dmxUniversesMu sync.Mutex
+	dmxUniverses   = make(map[string]*dmxUniverse)
+)
+
+// getDMXUniverse returns the universe described by c, shared with all other fixtures in the same
+// universe. The universe is created and started on first use and stopped by release after its last
+// fixture. It is an error to use another rate than the other fixtures of the universe.
+func getDMXUniverse(c dmxConfig) (*dmxUniverse, error) {
+	addr, err := dmxAddr(c)
+	if err != nil {
+		return nil, err
+	}
+	key := fmt.Sprintf("%s|%s|%d", c.Protocol, addr, c.Universe)
+
+	dmxUniversesMu.Lock()
+	defer dmxUniversesMu.Unlock()
+	if u, ok := dmxUniverses[key]; ok {
+		if u.Rate != c.Rate {
+			return nil, fmt.Errorf("dmx: universe %d is already sent %d times per second", c.Universe, u.Rate)
+		}
+		u.fixtures++
+		return u, nil
+	}
+
+	conn, err := net.DialUDP("udp4", nil, addr)
+	if err != nil {
+		return nil, err
+	}
+	u := &dmxUniverse{
+		dmxConfig: c,
+		key:       key,
+		conn:      conn,
+		fixtures:  1,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+		changed:   make(chan struct{}, 1),
+	}
+	rand.Read(u.cid[:])
+	dmxUniverses[key] = u
+	go u.run()
+	return u, nil
+}
+
+// release ends the use of u by a fixture. The last fixture stops u and closes its socket.
+func (u *dmxUniverse) release() error {
+	dmxUniversesMu.Lock()
+	u.fixtures--
+	last := u.fixtures == 0
+	if last {
+		delete(dmxUniverses, u.key)
+	}
+	dmxUniversesMu.Unlock()
+	if !last {
+		return nil
+	}
+	close(u.stop)
+	<-u.done
+	return u.conn.Close()
+}
+
+func dmxAddr(c dmxConfig) (*net.UDPAddr, error) {
+	host, port := c.Address, artNetPort
+	if c.Protocol == "sacn" {
+		port = sACNPort
+	}
+	if host == "" {
+		host = "255.255.255.255"
+		if c.Protocol == "sacn" {
+			host = fmt.Sprintf("239.255.%d.%d", c.Universe>>8, c.Universe&0xff)
+		}
+	}
+	if h, p, err := net.SplitHostPort(host); err == nil {
+		host = h
+		if port, err = strconv.Atoi(p); err != nil {
+			return nil, fmt.Errorf("dmx: invalid port '%s'", p)
+		}
+	}
+	return net.ResolveUDPAddr("udp4", net.JoinHostPort(host, strconv.Itoa(port)))
+}
+
+// set writes buf to the channels starting at channel (1-512) and sends the universe.
+func (u *dmxUniverse) set(channel int, buf []byte) error {
+	u.mu.Lock()
+	copy(u.data[channel-1:], buf)
+	// Art-Net requires an even length
+	u.length = max(u.length, channel-1+len(buf)+(channel-1+len(buf))%2)
+	u.mu.Unlock()
+
+	select {
+	case u.changed <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// run sends the universe on every change and at least rate times per second.
+func (u *dmxUniverse) run() {
+	defer close(u.done)
+	ticker := time.NewTicker(time.Second / time.Duration(u.Rate))
+	defer ticker.Stop()
+	failing := false
+	for {
+		select {
+		case <-u.stop:
+			return
+		case <-ticker.C:
+		case <-u.changed:
+		}
+		// only log changes, as the universe is sent many times per second
+		err := u.send()
+		if err != nil && !failing {
+			log.Printf("ERROR: could not send dmx universe %d: %+v", u.Universe, err)
+		} else if err == nil && failing {
+			log.Printf("Sending dmx universe %d again", u.Universe)
+		}
+		failing = err != nil
+	}
+}
+
+func (u *dmxUniverse) send() error {
+	u.mu.Lock()
+	if u.length == 0 {
+		u.mu.Unlock()
+		return nil
+	}
+	u.sequence++
+	if u.sequence == 0 && u.Protocol == "artnet" {
+		// 0 disables sequencing in Art-Net
+		u.sequence = 1
+	}
+	var pkt []byte
+	if u.Protocol == "artnet" {
+		pkt = artDMXPacket(u.Universe, u.sequence, u.data[:u.length])
+	} else {
+		pkt = sACNPacket(u.cid, u.Universe, u.sequence, u.data[:u.length])
+	}
+	u.mu.Unlock()
+
+	_, err := u.conn.Write(pkt)
+	return err
+}
+
+// artDMXPacket builds an Art-Net ArtDmx packet.
+func artDMXPacket(universe int, sequence uint8, data []byte) []byte {
+	pkt := make([]byte, 18, 18+len(data))
+	copy(pkt, "Art-Net\x00")
+	binary.LittleEndian.PutUint16(pkt[8:], 0x5000) // OpDmx
+	binary.BigEndian.PutUint16(pkt[10:], 14)       // protocol version
+	pkt[12] = sequence
+	pkt[13] = 0                    // physical port
+	pkt[14] = uint8(universe)      // SubUni
+	pkt[15] = uint8(universe >> 8) // Net
+	binary.BigEndian.PutUint16(pkt[16:], uint16(len(data)))
+	return append(pkt, data...)
+}
+
+// sACNPacket builds an E1.31 data packet.
+func sACNPacket(cid [16]byte, universe int, sequence uint8, data []byte) []byte {
+	pkt := make([]byte, 126, 126+len(data))
+
+	// root layer
+	binary.BigEndian.PutUint16(pkt[0:], 0x0010)
+	copy(pkt[4:], "ASC-E1.17\x00\x00\x00")
+	binary.BigEndian.PutUint16(pkt[16:], 0x7000|uint16(len(pkt)+len(data)-16))
+	binary.BigEndian.PutUint32(pkt[18:], 0x00000004)
+	copy(pkt[22:], cid[:])
+
+	// framing layer
+	binary.BigEndian.PutUint16(pkt[38:], 0x7000|uint16(len(pkt)+len(data)-38))
+	binary.BigEndian.PutUint32(pkt[40:], 0x00000002)
+	copy(pkt[44:108], "homeserver")
+	pkt[108] = 100 // priority
+	pkt[111] = sequence
+	binary.BigEndian.PutUint16(pkt[113:], uint16(universe))
+
+	// dmp layer
+	binary.BigEndian.PutUint16(pkt[115:], 0x7000|uint16(len(pkt)+len(data)-115))
+	pkt[117] = 0x02
+	pkt[118] = 0xa1
+	binary.BigEndian.PutUint16(pkt[121:], 0x0001)
+	binary.BigEndian.PutUint16(pkt[123:], uint16(len(data)+1))
+	pkt[125] = 0 // start code
+	return append(pkt, data...)
+}
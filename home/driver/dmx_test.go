@@ -0,0 +1,152 @@
+package driver
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestDMX(t *testing.T) {
+	tests := []struct {
+		name     string
+		protocol string
+		layout   string
+		wide     bool
+		state    State
+		want     []byte
+	}{
+		{"Art-Net dimmer", "artnet", "dimmer", false, State{On: true, Brightness: 127}, []byte{0x80}},
+		{"Art-Net rgb", "artnet", "rgb", false, State{On: true, Brightness: 254, Hue: 0, Saturation: 254, ColorMode: "hs"}, []byte{0xff, 0, 0}},
+		{"Art-Net rgbw off", "artnet", "rgbw", false, State{On: false, Brightness: 254, ColorMode: "hs"}, []byte{0, 0, 0, 0}},
+		{"sACN rgbw", "sacn", "rgbw", false, State{On: true, Brightness: 254, Hue: 0, Saturation: 127, ColorMode: "hs"}, []byte{0x7f, 0, 0, 0x80}},
+		{"sACN 16bit cct", "sacn", "cct", true, State{On: true, Brightness: 254, ColorTemperature: 500, ColorMode: "ct"}, []byte{0xff, 0xff, 0, 0}},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			receiver, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+			if err != nil {
+				t.Fatalf("could not listen: %v", err)
+			}
+			defer receiver.Close()
+
+			universe, channel := i+1, 10
+			d, err := New(Config{
+				"type":     "dmx",
+				"protocol": tt.protocol,
+				"address":  receiver.LocalAddr().String(),
+				"universe": universe,
+				"channel":  channel,
+				"layout":   tt.layout,
+				"16bit":    tt.wide,
+			})
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			if err = d.SetState(context.Background(), tt.state); err != nil {
+				t.Fatalf("SetState() error = %v", err)
+			}
+
+			buf := make([]byte, 1024)
+			receiver.SetReadDeadline(time.Now().Add(time.Second))
+			n, err := receiver.Read(buf)
+			if err != nil {
+				t.Fatalf("no packet received: %v", err)
+			}
+			pkt := buf[:n]
+
+			var gotUniverse int
+			var data []byte
+			switch tt.protocol {
+			case "artnet":
+				if !bytes.HasPrefix(pkt, []byte("Art-Net\x00")) || binary.LittleEndian.Uint16(pkt[8:]) != 0x5000 {
+					t.Fatalf("not an ArtDmx packet: %x", pkt)
+				}
+				gotUniverse = int(pkt[14]) | int(pkt[15])<<8
+				data = pkt[18 : 18+int(binary.BigEndian.Uint16(pkt[16:]))]
+			case "sacn":
+				if !bytes.Equal(pkt[4:16], []byte("ASC-E1.17\x00\x00\x00")) || pkt[125] != 0 {
+					t.Fatalf("not an E1.31 data packet: %x", pkt)
+				}
+				gotUniverse = int(binary.BigEndian.Uint16(pkt[113:]))
+				data = pkt[126 : 125+int(binary.BigEndian.Uint16(pkt[123:]))]
+			}
+			if gotUniverse != universe {
+				t.Errorf("universe want: %d; got %d", universe, gotUniverse)
+			}
+			if got := data[channel-1 : channel-1+len(tt.want)]; !bytes.Equal(got, tt.want) {
+				t.Errorf("channels want: %x; got %x", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestDMXUniverseLifecycle(t *testing.T) {
+	receiver, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("could not listen: %v", err)
+	}
+	defer receiver.Close()
+	fixture := func(channel, rate int) Config {
+		return Config{
+			"type":     "dmx",
+			"protocol": "artnet",
+			"address":  receiver.LocalAddr().String(),
+			"universe": 100,
+			"channel":  channel,
+			"layout":   "dimmer",
+			"rate":     rate,
+		}
+	}
+
+	first, err := New(fixture(1, 10))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	second, err := New(fixture(2, 10))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if _, err = New(fixture(3, 40)); err == nil {
+		t.Errorf("New() with another rate of the universe want error; got nil")
+	}
+	if first.(*dmx).universe != second.(*dmx).universe {
+		t.Errorf("fixtures of the same universe do not share it")
+	}
+
+	universes := func() int {
+		dmxUniversesMu.Lock()
+		defer dmxUniversesMu.Unlock()
+		return len(dmxUniverses)
+	}
+	before := universes()
+	if err = first.(Closer).Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if n := universes(); n != before {
+		t.Errorf("universe stopped while in use: %d universes; want %d", n, before)
+	}
+	u := second.(*dmx).universe
+	if err = second.(Closer).Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if n := universes(); n != before-1 {
+		t.Errorf("universe not removed after its last fixture: %d universes; want %d", n, before-1)
+	}
+	select {
+	case <-u.done:
+	default:
+		t.Errorf("universe still sending after its last fixture")
+	}
+	if err = second.SetState(context.Background(), State{On: true}); err == nil {
+		t.Errorf("SetState() after Close() want error; got nil")
+	}
+	// a new fixture may choose a new rate
+	third, err := New(fixture(3, 40))
+	if err != nil {
+		t.Fatalf("New() after the universe stopped error = %v", err)
+	}
+	third.(Closer).Close()
+}
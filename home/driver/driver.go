@@ -0,0 +1,165 @@
+package driver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"homeserver/config"
+	logger "log"
+	"sync"
+)
+
+var log *logger.Logger = logger.New(config.LogOutput, "[Driver] ", logger.LstdFlags|logger.Lmsgprefix)
+
+// State is the device independent state of a light. It uses the same value ranges as the hue api:
+//
+//	Brightness       1-254
+//	Hue              0-65535
+//	Saturation       0-254
+//	ColorTemperature 153-500 (mired)
+//	ColorMode        "hs", "xy", "ct" or "" for lights without color
+type State struct {
+	On               bool
+	Brightness       int
+	Hue              int
+	Saturation       int
+	ColorTemperature int
+	XY               [2]float32
+	ColorMode        string
+}
+
+// Driver controls the device behind a light.
+type Driver interface {
+	// SetState sends s to the device.
+	SetState(ctx context.Context, s State) error
+	// State reads the current state from the device.
+	State(ctx context.Context) (State, error)
+}
+
+// Notifier is implemented by drivers whose devices report state changes on their own.
+type Notifier interface {
+	// Notify registers f to be called with every state reported by the device.
+	Notify(f func(State))
+}
+
+// Prober is implemented by drivers with a cheaper or more precise way to check if their device is
+// reachable than reading its state.
+type Prober interface {
+	// Probe returns nil if the device is reachable.
+	Probe(ctx context.Context) error
+}
+
+// Closer is implemented by drivers holding resources like sockets or goroutines. Close is called
+// once the driver is replaced or its light is removed.
+type Closer interface {
+	Close() error
+}
+
+// ErrNoState is returned by Driver.State for devices that can not report their state and have not
+// been set yet.
+var ErrNoState = errors.New("device does not report its state")
+
+// ErrUnreachable is returned by drivers when their device can currently not be reached.
+var ErrUnreachable = errors.New("device is not reachable")
+
+// Discoverer searches the local network for devices it can drive.
+type Discoverer interface {
+	Discover(ctx context.Context) ([]Device, error)
+}
+
+// Device is a device found by a Discoverer.
+type Device struct {
+	Name             string
+	Type             string
+	ModelID          string
+	ManufacturerName string
+	ProductName      string
+	UniqueID         string
+	Config           Config
+}
+
+// Config is the driver configuration of a light as it is stored in the "driver" field of a light.
+// The "type" key selects the driver, all other keys are driver specific.
+type Config map[string]any
+
+// Type returns the name of the driver c is meant for.
+func (c Config) Type() string {
+	t, _ := c["type"].(string)
+	return t
+}
+
+// Decode stores the driver specific settings of c into v.
+func (c Config) Decode(v any) error {
+	buf, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(buf, v)
+}
+
+// Factory creates a new driver from its config.
+type Factory func(c Config) (Driver, error)
+
+var (
+	registryMu  sync.RWMutex
+	factories   = make(map[string]Factory)
+	discoverers = make(map[string]Discoverer)
+)
+
+// Register makes a driver available under the given name. It panics if Register is called twice
+// with the same name.
+func Register(name string, f Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, ok := factories[name]; ok {
+		panic(fmt.Sprintf("driver '%s' is already registered", name))
+	}
+	factories[name] = f
+}
+
+// RegisterDiscoverer adds d to the discoverers used by Discover.
+func RegisterDiscoverer(name string, d Discoverer) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	discoverers[name] = d
+}
+
+// New creates the driver selected by c.Type().
+func New(c Config) (Driver, error) {
+	registryMu.RLock()
+	f, ok := factories[c.Type()]
+	registryMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown driver '%s'", c.Type())
+	}
+	return f(c)
+}
+
+// Discover runs all registered discoverers concurrently and returns every device found. Errors of
+// single discoverers are only logged.
+func Discover(ctx context.Context) []Device {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		devices []Device
+	)
+	for name, d := range discoverers {
+		wg.Add(1)
+		go func(name string, d Discoverer) {
+			defer wg.Done()
+			found, err := d.Discover(ctx)
+			if err != nil {
+				log.Printf("ERROR: %s discovery failed: %+v", name, err)
+			}
+			mu.Lock()
+			devices = append(devices, found...)
+			mu.Unlock()
+		}(name, d)
+	}
+	wg.Wait()
+	return devices
+}
@@ -0,0 +1,168 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+
+	"golang.org/x/exp/slices"
+)
+
+func init() {
+	Register("homeassistant", newHomeAssistant)
+}
+
+// homeAssistantConfig are the settings of the "homeassistant" driver.
+//
+//	address string the url of the Home Assistant instance, e.g. "http://homeassistant.local:8123"
+//	token   string a long-lived access token
+//	entity  string the entity id of the light, e.g. "light.kitchen"
+type homeAssistantConfig struct {
+	Address string `json:"address"`
+	Token   string `json:"token"`
+	Entity  string `json:"entity"`
+}
+
+// homeAssistant drives a light entity of Home Assistant through its rest api.
+type homeAssistant struct {
+	homeAssistantConfig
+}
+
+type haState struct {
+	EntityID   string `json:"entity_id"`
+	State      string `json:"state"`
+	Attributes struct {
+		FriendlyName        string    `json:"friendly_name"`
+		Brightness          *int      `json:"brightness"`
+		ColorMode           string    `json:"color_mode"`
+		HSColor             []float64 `json:"hs_color"`
+		XYColor             []float32 `json:"xy_color"`
+		ColorTemp           *int      `json:"color_temp"`
+		SupportedColorModes []string  `json:"supported_color_modes"`
+	} `json:"attributes"`
+}
+
+func newHomeAssistant(c Config) (Driver, error) {
+	h := &homeAssistant{}
+	if err := c.Decode(&h.homeAssistantConfig); err != nil {
+		return nil, err
+	}
+	if h.Address == "" || h.Token == "" || h.Entity == "" {
+		return nil, fmt.Errorf("homeassistant: address, token and entity are required")
+	}
+	return h, nil
+}
+
+func (h *homeAssistant) SetState(ctx context.Context, s State) error {
+	req := map[string]any{"entity_id": h.Entity}
+	service := "turn_off"
+	if s.On {
+		service = "turn_on"
+		if s.Brightness > 0 {
+			req["brightness"] = ScaleBrightness(s.Brightness, 255)
+		}
+		switch s.ColorMode {
+		case "hs":
+			req["hs_color"] = []float64{
+				math.Round(float64(s.Hue)/65535*3600) / 10,
+				math.Round(float64(s.Saturation)/254*1000) / 10,
+			}
+		case "xy":
+			req["xy_color"] = s.XY
+		case "ct":
+			req["color_temp"] = s.ColorTemperature
+		}
+	}
+
+	return doJSON(ctx, http.MethodPost, baseURL(h.Address)+"/api/services/light/"+service, h.header(), req, nil)
+}
+
+func (h *homeAssistant) State(ctx context.Context) (State, error) {
+	var resp haState
+	if err := doJSON(ctx, http.MethodGet, baseURL(h.Address)+"/api/states/"+h.Entity, h.header(), nil, &resp); err != nil {
+		return State{}, err
+	}
+	if resp.State != "on" && resp.State != "off" {
+		// "unavailable" or "unknown", Home Assistant lost the device
+		return State{}, fmt.Errorf("homeassistant: entity %s is %s: %w", h.Entity, resp.State, ErrUnreachable)
+	}
+	return resp.driverState(), nil
+}
+
+func (h *homeAssistant) header() http.Header {
+	return http.Header{"Authorization": {"Bearer " + h.Token}}
+}
+
+func (s haState) driverState() State {
+	a := s.Attributes
+	state := State{On: s.State == "on"}
+	if a.Brightness != nil {
+		state.Brightness = UnscaleBrightness(*a.Brightness, 255)
+	}
+	switch {
+	case a.ColorMode == "color_temp" && a.ColorTemp != nil:
+		state.ColorMode = "ct"
+		state.ColorTemperature = *a.ColorTemp
+	case a.ColorMode == "xy" && len(a.XYColor) == 2:
+		state.ColorMode = "xy"
+		state.XY = [2]float32{a.XYColor[0], a.XYColor[1]}
+	case len(a.HSColor) == 2:
+		state.ColorMode = "hs"
+		state.Hue = int(math.Round(a.HSColor[0] / 360 * 65535))
+		state.Saturation = int(math.Round(a.HSColor[1] / 100 * 254))
+	}
+	return state
+}
+
+// lightType returns the hue light type matching the supported color modes of s.
+func (s haState) lightType() string {
+	modes := s.Attributes.SupportedColorModes
+	color := slices.ContainsFunc(modes, func(m string) bool {
+		return m == "hs" || m == "xy" || strings.HasPrefix(m, "rgb")
+	})
+	ct := slices.Contains(modes, "color_temp")
+	switch {
+	case color && ct:
+		return "Extended color light"
+	case color:
+		return "Color light"
+	case ct:
+		return "Color temperature light"
+	case slices.Contains(modes, "brightness") || slices.Contains(modes, "white"):
+		return "Dimmable light"
+	}
+	return "On/off light"
+}
+
+// HomeAssistantLights returns all light entities of the Home Assistant instance at address as
+// devices using the "homeassistant" driver.
+func HomeAssistantLights(ctx context.Context, address, token string) ([]Device, error) {
+	var states []haState
+	header := http.Header{"Authorization": {"Bearer " + token}}
+	if err := doJSON(ctx, http.MethodGet, baseURL(address)+"/api/states", header, nil, &states); err != nil {
+		return nil, err
+	}
+
+	var devices []Device
+	for _, s := range states {
+		if !strings.HasPrefix(s.EntityID, "light.") {
+			continue
+		}
+		name := s.Attributes.FriendlyName
+		if name == "" {
+			name = s.EntityID
+		}
+		devices = append(devices, Device{
+			Name:             name,
+			Type:             s.lightType(),
+			ManufacturerName: "Home Assistant",
+			UniqueID:         "homeassistant:" + s.EntityID,
+			Config:           Config{"type": "homeassistant", "address": address, "token": token, "entity": s.EntityID},
+		})
+	}
+	sort.Slice(devices, func(i, j int) bool { return devices[i].UniqueID < devices[j].UniqueID })
+	return devices, nil
+}
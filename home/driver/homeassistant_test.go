@@ -0,0 +1,90 @@
+package driver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// fakeHomeAssistant stands in for the rest api of Home Assistant with a single light entity.
+type fakeHomeAssistant struct {
+	state   string
+	service map[string]any
+}
+
+func (f *fakeHomeAssistant) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/api/services/light/"):
+		f.service = make(map[string]any)
+		json.NewDecoder(r.Body).Decode(&f.service)
+		f.state = strings.TrimPrefix(r.URL.Path, "/api/services/light/turn_")
+		w.Write([]byte("[]"))
+	case r.URL.Path == "/api/states/light.desk":
+		w.Write([]byte(`{"entity_id": "light.desk", "state": "` + f.state + `", "attributes": {
+			"brightness": 255, "color_mode": "hs", "hs_color": [180, 50]}}`))
+	case r.URL.Path == "/api/states":
+		w.Write([]byte(`[
+			{"entity_id": "light.desk", "state": "off", "attributes": {"friendly_name": "Desk", "supported_color_modes": ["color_temp", "hs"]}},
+			{"entity_id": "light.hall", "state": "on", "attributes": {"supported_color_modes": ["onoff"]}},
+			{"entity_id": "switch.fan", "state": "on", "attributes": {}}
+		]`))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestHomeAssistant(t *testing.T) {
+	fake := &fakeHomeAssistant{state: "off"}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	ctx := context.Background()
+
+	devices, err := HomeAssistantLights(ctx, srv.URL, "secret")
+	if err != nil {
+		t.Fatalf("HomeAssistantLights() error = %v", err)
+	}
+	if len(devices) != 2 ||
+		devices[0].Name != "Desk" || devices[0].Type != "Extended color light" ||
+		devices[1].Name != "light.hall" || devices[1].Type != "On/off light" {
+		t.Fatalf("HomeAssistantLights() want: [Desk light.hall]; got %+v", devices)
+	}
+
+	d, err := New(devices[0].Config)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if err = d.SetState(ctx, State{On: true, Brightness: 254, ColorMode: "ct", ColorTemperature: 300}); err != nil {
+		t.Fatalf("SetState() error = %v", err)
+	}
+	if fake.state != "on" || fake.service["entity_id"] != "light.desk" ||
+		fake.service["brightness"] != 255.0 || fake.service["color_temp"] != 300.0 {
+		t.Errorf("SetState() want turn_on with brightness 255 and color_temp 300; got turn_%s %v", fake.state, fake.service)
+	}
+
+	s, err := d.State(ctx)
+	if err != nil {
+		t.Fatalf("State() error = %v", err)
+	}
+	if want := (State{On: true, Brightness: 254, Hue: 32768, Saturation: 127, ColorMode: "hs"}); s != want {
+		t.Errorf("State() want: %+v; got %+v", want, s)
+	}
+
+	if err = d.SetState(ctx, State{On: false}); err != nil || fake.state != "off" {
+		t.Errorf("SetState() want turn_off; got turn_%s, error = %v", fake.state, err)
+	}
+
+	for _, state := range []string{"unavailable", "unknown"} {
+		fake.state = state
+		if _, err = d.State(ctx); !errors.Is(err, ErrUnreachable) {
+			t.Errorf("State() of %s entity want: %v; got %v", state, ErrUnreachable, err)
+		}
+	}
+}
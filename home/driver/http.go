@@ -0,0 +1,67 @@
+package driver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var httpClient = &http.Client{Timeout: 5 * time.Second}
+
+// baseURL returns address as an url without a trailing slash. If address has no scheme "http://" is
+// used.
+func baseURL(address string) string {
+	if !strings.Contains(address, "://") {
+		address = "http://" + address
+	}
+	return strings.TrimSuffix(address, "/")
+}
+
+// doJSON sends a request with body encoded as json (if not nil) and decodes the json response into
+// v (if not nil).
+func doJSON(ctx context.Context, method, url string, header http.Header, body, v any) error {
+	var reqBody io.Reader
+	if body != nil {
+		buf, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(buf)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, reqBody)
+	if err != nil {
+		return err
+	}
+	for k, values := range header {
+		for _, value := range values {
+			req.Header.Add(k, value)
+		}
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	buf, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("%s %s: %s: %s", method, url, resp.Status, bytes.TrimSpace(buf))
+	}
+	if v == nil || len(buf) == 0 {
+		return nil
+	}
+	return json.Unmarshal(buf, v)
+}
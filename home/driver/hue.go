@@ -0,0 +1,164 @@
+package driver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+)
+
+func init() {
+	Register("hue", newHue)
+}
+
+// hueConfig are the settings of the "hue" driver.
+//
+//	address  string the ip or url of the upstream hue bridge
+//	username string a registered user of the upstream bridge
+//	light    string the id of the light on the upstream bridge
+type hueConfig struct {
+	Address  string `json:"address"`
+	Username string `json:"username"`
+	Light    string `json:"light"`
+}
+
+// hue forwards the state of a light to a light of an upstream hue bridge using the v1 api.
+type hue struct {
+	hueConfig
+}
+
+type hueLight struct {
+	State struct {
+		On               bool       `json:"on"`
+		Brightness       int        `json:"bri"`
+		Hue              int        `json:"hue"`
+		Saturation       int        `json:"sat"`
+		ColorTemperature int        `json:"ct"`
+		XY               [2]float32 `json:"xy"`
+		ColorMode        string     `json:"colormode"`
+	} `json:"state"`
+	Type             string `json:"type"`
+	Name             string `json:"name"`
+	ModelID          string `json:"modelid"`
+	ManufacturerName string `json:"manufacturername"`
+	ProductName      string `json:"productname"`
+	UniqueID         string `json:"uniqueid"`
+}
+
+type hueError struct {
+	Error *struct {
+		Type        int    `json:"type"`
+		Address     string `json:"address"`
+		Description string `json:"description"`
+	} `json:"error"`
+}
+
+func newHue(c Config) (Driver, error) {
+	h := &hue{}
+	if err := c.Decode(&h.hueConfig); err != nil {
+		return nil, err
+	}
+	if h.Address == "" || h.Username == "" || h.Light == "" {
+		return nil, fmt.Errorf("hue: address, username and light are required")
+	}
+	return h, nil
+}
+
+func (h *hue) SetState(ctx context.Context, s State) error {
+	req := map[string]any{"on": s.On}
+	// the bridge rejects all other attributes while the light is off
+	if s.On {
+		if s.Brightness > 0 {
+			req["bri"] = s.Brightness
+		}
+		switch s.ColorMode {
+		case "hs":
+			req["hue"] = s.Hue
+			req["sat"] = s.Saturation
+		case "xy":
+			req["xy"] = s.XY
+		case "ct":
+			req["ct"] = s.ColorTemperature
+		}
+	}
+
+	var resp json.RawMessage
+	url := fmt.Sprintf("%s/api/%s/lights/%s/state", baseURL(h.Address), h.Username, h.Light)
+	if err := doJSON(ctx, http.MethodPut, url, nil, req, &resp); err != nil {
+		return err
+	}
+	return checkHueError(resp)
+}
+
+func (h *hue) State(ctx context.Context) (State, error) {
+	var resp json.RawMessage
+	url := fmt.Sprintf("%s/api/%s/lights/%s", baseURL(h.Address), h.Username, h.Light)
+	if err := doJSON(ctx, http.MethodGet, url, nil, nil, &resp); err != nil {
+		return State{}, err
+	}
+	if err := checkHueError(resp); err != nil {
+		return State{}, err
+	}
+
+	var l hueLight
+	if err := json.Unmarshal(resp, &l); err != nil {
+		return State{}, err
+	}
+	return State{
+		On:               l.State.On,
+		Brightness:       l.State.Brightness,
+		Hue:              l.State.Hue,
+		Saturation:       l.State.Saturation,
+		ColorTemperature: l.State.ColorTemperature,
+		XY:               l.State.XY,
+		ColorMode:        l.State.ColorMode,
+	}, nil
+}
+
+// HueLights returns all lights of the hue bridge at address as devices using the "hue" driver.
+func HueLights(ctx context.Context, address, username string) ([]Device, error) {
+	var resp json.RawMessage
+	if err := doJSON(ctx, http.MethodGet, fmt.Sprintf("%s/api/%s/lights", baseURL(address), username), nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	if err := checkHueError(resp); err != nil {
+		return nil, err
+	}
+
+	lights := make(map[string]hueLight)
+	if err := json.Unmarshal(resp, &lights); err != nil {
+		return nil, err
+	}
+
+	devices := make([]Device, 0, len(lights))
+	for id, l := range lights {
+		devices = append(devices, Device{
+			Name:             l.Name,
+			Type:             l.Type,
+			ModelID:          l.ModelID,
+			ManufacturerName: l.ManufacturerName,
+			ProductName:      l.ProductName,
+			UniqueID:         l.UniqueID,
+			Config:           Config{"type": "hue", "address": address, "username": username, "light": id},
+		})
+	}
+	sort.Slice(devices, func(i, j int) bool { return devices[i].UniqueID < devices[j].UniqueID })
+	return devices, nil
+}
+
+// checkHueError returns the first error of a hue api response, if any. The hue api reports errors
+// as a list of error objects with a 200 status code.
+func checkHueError(resp json.RawMessage) error {
+	var errs []hueError
+	if json.Unmarshal(resp, &errs) != nil {
+		// not a list, so not an error response
+		return nil
+	}
+	for _, e := range errs {
+		if e.Error != nil {
+			return fmt.Errorf("hue: error %d at %s: %s", e.Error.Type, e.Error.Address, e.Error.Description)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,297 @@
+package driver
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"time"
+)
+
+func init() {
+	Register("lifx", newLIFX)
+	RegisterDiscoverer("lifx", lifxDiscoverer{})
+}
+
+const lifxPort = 56700
+
+// lifxBroadcastAddr is where GetService messages are sent to during discovery.
+var lifxBroadcastAddr = &net.UDPAddr{IP: net.IPv4bcast, Port: lifxPort}
+
+// message types of the LIFX LAN protocol
+const (
+	lifxGetService      uint16 = 2
+	lifxStateService    uint16 = 3
+	lifxAcknowledgement uint16 = 45
+	lifxLightGet        uint16 = 101
+	lifxLightSetColor   uint16 = 102
+	lifxLightState      uint16 = 107
+	lifxLightSetPower   uint16 = 117
+)
+
+// lifxConfig are the settings of the "lifx" driver.
+//
+//	address string the ip of the bulb, optionally with port
+//	mac     string the mac address of the bulb; if empty messages are sent to all bulbs at address
+type lifxConfig struct {
+	Address string `json:"address"`
+	MAC     string `json:"mac,omitempty"`
+}
+
+// lifx drives a LIFX bulb using the LAN protocol.
+type lifx struct {
+	lifxConfig
+	target [8]byte
+	source uint32
+}
+
+// lifxHeader is the frame header, frame address and protocol header of a LIFX message.
+type lifxHeader struct {
+	Size     uint16
+	Protocol uint16
+	Source   uint32
+	Target   [8]byte
+	_        [6]byte
+	Flags    uint8
+	Sequence uint8
+	_        uint64
+	Type     uint16
+	_        uint16
+}
+
+// lifxHSBK is a color as used by the LIFX LAN protocol.
+type lifxHSBK struct {
+	Hue, Saturation, Brightness, Kelvin uint16
+}
+
+// lifxState is the payload of a Light::State message.
+type lifxState struct {
+	Color lifxHSBK
+	_     int16
+	Power uint16
+	Label [32]byte
+	_     uint64
+}
+
+func newLIFX(c Config) (Driver, error) {
+	l := &lifx{source: rand.Uint32() | 1}
+	if err := c.Decode(&l.lifxConfig); err != nil {
+		return nil, err
+	}
+	if l.Address == "" {
+		return nil, fmt.Errorf("lifx: missing address")
+	}
+	if l.MAC != "" {
+		mac, err := net.ParseMAC(l.MAC)
+		if err != nil {
+			return nil, fmt.Errorf("lifx: %v", err)
+		}
+		copy(l.target[:], mac)
+	}
+	return l, nil
+}
+
+func (l *lifx) SetState(ctx context.Context, s State) error {
+	color := lifxHSBK{Brightness: uint16(ScaleBrightness(s.Brightness, 0xffff)), Kelvin: 3500}
+	if s.Brightness == 0 {
+		color.Brightness = 0xffff
+	}
+	switch s.ColorMode {
+	case "hs":
+		color.Hue = uint16(s.Hue)
+		color.Saturation = uint16(ScaleBrightness(s.Saturation, 0xffff))
+	case "xy":
+		hue, sat := RGBToHS(XYToRGB(s.XY[0], s.XY[1]))
+		color.Hue = uint16(hue)
+		color.Saturation = uint16(ScaleBrightness(sat, 0xffff))
+	case "ct":
+		color.Kelvin = uint16(min(max(MiredToKelvin(s.ColorTemperature), 1500), 9000))
+	}
+
+	payload := new(bytes.Buffer)
+	binary.Write(payload, binary.LittleEndian, uint8(0))
+	binary.Write(payload, binary.LittleEndian, color)
+	binary.Write(payload, binary.LittleEndian, uint32(0))
+	if _, err := l.request(ctx, lifxLightSetColor, payload.Bytes(), lifxAcknowledgement); err != nil {
+		return err
+	}
+
+	var level uint16
+	if s.On {
+		level = 0xffff
+	}
+	payload.Reset()
+	binary.Write(payload, binary.LittleEndian, level)
+	binary.Write(payload, binary.LittleEndian, uint32(0))
+	_, err := l.request(ctx, lifxLightSetPower, payload.Bytes(), lifxAcknowledgement)
+	return err
+}
+
+func (l *lifx) State(ctx context.Context) (State, error) {
+	resp, err := l.request(ctx, lifxLightGet, nil, lifxLightState)
+	if err != nil {
+		return State{}, err
+	}
+	var ls lifxState
+	if err = binary.Read(bytes.NewReader(resp), binary.LittleEndian, &ls); err != nil {
+		return State{}, err
+	}
+	return ls.driverState(), nil
+}
+
+func (ls lifxState) driverState() State {
+	s := State{
+		On:         ls.Power > 0,
+		Brightness: UnscaleBrightness(int(ls.Color.Brightness), 0xffff),
+	}
+	if ls.Color.Saturation == 0 {
+		s.ColorMode = "ct"
+		s.ColorTemperature = KelvinToMired(int(ls.Color.Kelvin))
+	} else {
+		s.ColorMode = "hs"
+		s.Hue = int(ls.Color.Hue)
+		s.Saturation = UnscaleBrightness(int(ls.Color.Saturation), 0xffff)
+	}
+	return s
+}
+
+// request sends a message of type typ to the bulb and waits for a reply of type respType. The
+// message is sent up to three times, as udp packets may get lost.
+func (l *lifx) request(ctx context.Context, typ uint16, payload []byte, respType uint16) ([]byte, error) {
+	address := l.Address
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, strconv.Itoa(lifxPort))
+	}
+	conn, err := net.Dial("udp4", address)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	flags := uint8(0x01) // res_required
+	if respType == lifxAcknowledgement {
+		flags = 0x02 // ack_required
+	}
+	msg := lifxMessage(l.source, l.target, flags, 0, typ, payload)
+
+	buf := make([]byte, 1024)
+	for attempt := 0; attempt < 3; attempt++ {
+		if _, err = conn.Write(msg); err != nil {
+			return nil, err
+		}
+		deadline := time.Now().Add(500 * time.Millisecond)
+		if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+			deadline = d
+		}
+		conn.SetReadDeadline(deadline)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				break
+			}
+			h, resp, err := parseLIFXMessage(buf[:n])
+			if err == nil && h.Source == l.source && h.Type == respType {
+				return resp, nil
+			}
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+	}
+	return nil, fmt.Errorf("lifx: no response from %s", address)
+}
+
+// lifxMessage encodes a LIFX message. A zero target addresses all devices (tagged).
+func lifxMessage(source uint32, target [8]byte, flags, sequence uint8, typ uint16, payload []byte) []byte {
+	h := lifxHeader{
+		Size:     uint16(36 + len(payload)),
+		Protocol: 1024 | 1<<12, // protocol 1024, addressable
+		Source:   source,
+		Target:   target,
+		Flags:    flags,
+		Sequence: sequence,
+		Type:     typ,
+	}
+	if target == [8]byte{} {
+		h.Protocol |= 1 << 13 // tagged
+	}
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, h)
+	buf.Write(payload)
+	return buf.Bytes()
+}
+
+func parseLIFXMessage(msg []byte) (h lifxHeader, payload []byte, err error) {
+	if len(msg) < 36 {
+		return h, nil, fmt.Errorf("lifx: message too short")
+	}
+	if err = binary.Read(bytes.NewReader(msg), binary.LittleEndian, &h); err != nil {
+		return h, nil, err
+	}
+	if int(h.Size) != len(msg) {
+		return h, nil, fmt.Errorf("lifx: invalid message size %d", h.Size)
+	}
+	return h, msg[36:], nil
+}
+
+// lifxDiscoverer finds LIFX bulbs by broadcasting GetService messages.
+type lifxDiscoverer struct{}
+
+func (lifxDiscoverer) Discover(ctx context.Context) ([]Device, error) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	source := rand.Uint32() | 1
+	if _, err = conn.WriteTo(lifxMessage(source, [8]byte{}, 0x01, 0, lifxGetService, nil), lifxBroadcastAddr); err != nil {
+		return nil, err
+	}
+
+	// leave time to query the found bulbs
+	deadline := time.Now().Add(time.Second)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetReadDeadline(deadline)
+
+	found := make(map[[8]byte]string)
+	buf := make([]byte, 1024)
+	for {
+		n, addr, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			break
+		}
+		h, payload, err := parseLIFXMessage(buf[:n])
+		if err != nil || h.Source != source || h.Type != lifxStateService || len(payload) < 5 || payload[0] != 1 {
+			continue
+		}
+		port := binary.LittleEndian.Uint32(payload[1:])
+		found[h.Target] = net.JoinHostPort(addr.IP.String(), strconv.Itoa(int(port)))
+	}
+
+	devices := make([]Device, 0, len(found))
+	for target, address := range found {
+		mac := net.HardwareAddr(target[:6]).String()
+		d, _ := newLIFX(Config{"address": address, "mac": mac})
+		resp, err := d.(*lifx).request(ctx, lifxLightGet, nil, lifxLightState)
+		if err != nil {
+			log.Printf("ERROR: could not get state of LIFX bulb %s: %+v", mac, err)
+			continue
+		}
+		var ls lifxState
+		binary.Read(bytes.NewReader(resp), binary.LittleEndian, &ls)
+		devices = append(devices, Device{
+			Name:             string(bytes.TrimRight(ls.Label[:], "\x00")),
+			Type:             "Extended color light",
+			ManufacturerName: "LIFX",
+			UniqueID:         mac + "-0b",
+			Config:           Config{"type": "lifx", "address": address, "mac": mac},
+		})
+	}
+	return devices, nil
+}
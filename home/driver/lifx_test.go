@@ -0,0 +1,109 @@
+package driver
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeLIFX is a LIFX bulb listening on loopback.
+type fakeLIFX struct {
+	conn *net.UDPConn
+	mac  [8]byte
+
+	mu    sync.Mutex
+	color lifxHSBK
+	power uint16
+}
+
+func newFakeLIFX(t *testing.T) *fakeLIFX {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("could not listen: %v", err)
+	}
+	f := &fakeLIFX{conn: conn, mac: [8]byte{0xd0, 0x73, 0xd5, 0x01, 0x02, 0x03}}
+	t.Cleanup(func() { conn.Close() })
+	go f.serve()
+	return f
+}
+
+func (f *fakeLIFX) serve() {
+	buf := make([]byte, 1024)
+	for {
+		n, addr, err := f.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		h, payload, err := parseLIFXMessage(buf[:n])
+		if err != nil || (h.Target != [8]byte{} && h.Target != f.mac) {
+			continue
+		}
+
+		f.mu.Lock()
+		resp := new(bytes.Buffer)
+		respType := lifxAcknowledgement
+		switch h.Type {
+		case lifxGetService:
+			respType = lifxStateService
+			binary.Write(resp, binary.LittleEndian, uint8(1))
+			binary.Write(resp, binary.LittleEndian, uint32(f.conn.LocalAddr().(*net.UDPAddr).Port))
+		case lifxLightGet:
+			respType = lifxLightState
+			state := lifxState{Color: f.color, Power: f.power}
+			copy(state.Label[:], "Fake Bulb")
+			binary.Write(resp, binary.LittleEndian, state)
+		case lifxLightSetColor:
+			binary.Read(bytes.NewReader(payload[1:]), binary.LittleEndian, &f.color)
+		case lifxLightSetPower:
+			f.power = binary.LittleEndian.Uint16(payload)
+		}
+		f.mu.Unlock()
+
+		f.conn.WriteToUDP(lifxMessage(h.Source, f.mac, 0, h.Sequence, respType, resp.Bytes()), addr)
+	}
+}
+
+func TestLIFX(t *testing.T) {
+	fake := newFakeLIFX(t)
+	defer func(addr *net.UDPAddr) { lifxBroadcastAddr = addr }(lifxBroadcastAddr)
+	lifxBroadcastAddr = fake.conn.LocalAddr().(*net.UDPAddr)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	devices, err := lifxDiscoverer{}.Discover(ctx)
+	if err != nil {
+		t.Fatalf("Discover() error = %v", err)
+	}
+	if len(devices) != 1 || devices[0].Name != "Fake Bulb" || devices[0].Config["mac"] != "d0:73:d5:01:02:03" {
+		t.Fatalf("Discover() want: [Fake Bulb]; got %+v", devices)
+	}
+
+	d, err := New(devices[0].Config)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	ctx = context.Background()
+	if err = d.SetState(ctx, State{On: true, Brightness: 254, Hue: 21845, Saturation: 254, ColorMode: "hs"}); err != nil {
+		t.Fatalf("SetState() error = %v", err)
+	}
+	fake.mu.Lock()
+	if want := (lifxHSBK{21845, 0xffff, 0xffff, 3500}); fake.color != want || fake.power != 0xffff {
+		t.Errorf("SetState() want: %+v on; got %+v power %d", want, fake.color, fake.power)
+	}
+	fake.mu.Unlock()
+
+	if err = d.SetState(ctx, State{On: true, Brightness: 127, ColorTemperature: 250, ColorMode: "ct"}); err != nil {
+		t.Fatalf("SetState() error = %v", err)
+	}
+	s, err := d.State(ctx)
+	if err != nil {
+		t.Fatalf("State() error = %v", err)
+	}
+	if want := (State{On: true, Brightness: 127, ColorTemperature: 250, ColorMode: "ct"}); s != want {
+		t.Errorf("State() want: %+v; got %+v", want, s)
+	}
+}
@@ -0,0 +1,98 @@
+package driver
+
+import (
+	"context"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+var mdnsAddr = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+// mdnsService is a service instance found by browseMDNS.
+type mdnsService struct {
+	Instance string
+	Host     string
+	Addr     net.IP
+	Port     int
+}
+
+// browseMDNS sends a single mDNS query for service (e.g. "_wled._tcp.local.") and collects the
+// answers until ctx is done.
+//
+// The query is sent from an ephemeral port, so responders answer directly via unicast.
+func browseMDNS(ctx context.Context, service string) ([]mdnsService, error) {
+	name, err := dnsmessage.NewName(service)
+	if err != nil {
+		return nil, err
+	}
+	query := dnsmessage.Message{
+		Questions: []dnsmessage.Question{{Name: name, Type: dnsmessage.TypePTR, Class: dnsmessage.ClassINET}},
+	}
+	buf, err := query.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if _, err = conn.WriteTo(buf, mdnsAddr); err != nil {
+		return nil, err
+	}
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(3 * time.Second)
+	}
+	conn.SetReadDeadline(deadline)
+
+	var (
+		instances = make(map[string]*mdnsService)
+		hosts     = make(map[string]net.IP)
+	)
+	buf = make([]byte, 9000)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			break
+		}
+		var msg dnsmessage.Message
+		if err = msg.Unpack(buf[:n]); err != nil {
+			continue
+		}
+		for _, r := range append(msg.Answers, msg.Additionals...) {
+			switch body := r.Body.(type) {
+			case *dnsmessage.PTRResource:
+				if strings.EqualFold(r.Header.Name.String(), service) {
+					instance := body.PTR.String()
+					if _, ok := instances[instance]; !ok {
+						instances[instance] = &mdnsService{Instance: instance}
+					}
+				}
+			case *dnsmessage.SRVResource:
+				instance := r.Header.Name.String()
+				if _, ok := instances[instance]; !ok {
+					instances[instance] = &mdnsService{Instance: instance}
+				}
+				instances[instance].Host = body.Target.String()
+				instances[instance].Port = int(body.Port)
+			case *dnsmessage.AResource:
+				hosts[r.Header.Name.String()] = net.IP(body.A[:])
+			}
+		}
+	}
+
+	services := make([]mdnsService, 0, len(instances))
+	for _, s := range instances {
+		if s.Addr = hosts[s.Host]; s.Addr == nil {
+			continue
+		}
+		services = append(services, *s)
+	}
+	return services, nil
+}
@@ -0,0 +1,547 @@
+package driver
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"homeserver/config"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PLUGINS is the collection of the config store holding the plugins to start, indexed by their
+// name.
+const PLUGINS string = "plugins"
+
+func init() {
+	Register("plugin", newPluginDriver)
+	RegisterDiscoverer("plugin", pluginDiscoverer{})
+}
+
+var (
+	pluginHealthInterval = 30 * time.Second
+	pluginMinBackoff     = time.Second
+	pluginMaxBackoff     = 5 * time.Minute
+)
+
+// pluginConfig is the configuration of a plugin as stored in the plugin file.
+//
+//	command string   the executable of the plugin
+//	args    []string arguments passed to the plugin
+//	env     []string additional environment variables in the form "key=value"
+type pluginConfig struct {
+	Command string   `json:"command"`
+	Args    []string `json:"args,omitempty"`
+	Env     []string `json:"env,omitempty"`
+}
+
+type rpcMessage struct {
+	Version string          `json:"jsonrpc"`
+	ID      *int            `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  any             `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string {
+	return fmt.Sprintf("%s (%d)", e.Message, e.Code)
+}
+
+// rpcState is a State as sent over the plugin protocol.
+type rpcState struct {
+	On               bool       `json:"on"`
+	Brightness       int        `json:"bri,omitempty"`
+	Hue              int        `json:"hue,omitempty"`
+	Saturation       int        `json:"sat,omitempty"`
+	ColorTemperature int        `json:"ct,omitempty"`
+	XY               [2]float32 `json:"xy,omitempty"`
+	ColorMode        string     `json:"colormode,omitempty"`
+}
+
+type rpcDevice struct {
+	Device           string `json:"device"`
+	Name             string `json:"name"`
+	Type             string `json:"type"`
+	ModelID          string `json:"modelid"`
+	ManufacturerName string `json:"manufacturername"`
+	ProductName      string `json:"productname"`
+	UniqueID         string `json:"uniqueid"`
+}
+
+type rpcStateChange struct {
+	Device string   `json:"device"`
+	State  rpcState `json:"state"`
+}
+
+// plugin is a supervised plugin process.
+type plugin struct {
+	name string
+	pluginConfig
+	onDevices func([]Device)
+
+	writeMu sync.Mutex
+	mu      sync.Mutex
+	stdin   io.Writer
+	kill    func()
+	running bool
+	nextID  int
+	pending map[int]chan rpcMessage
+	states  map[string]State
+	notify  map[string]func(State)
+}
+
+var (
+	pluginsMu sync.RWMutex
+	plugins   = make(map[string]*plugin)
+)
+
+// StartPlugins starts all plugins configured in the plugin file and restarts them when they crash,
+// until ctx is done. onDevices is called with the devices of a plugin every time it has started.
+//
+// Plugins are external processes providing devices. They speak JSON-RPC 2.0 over stdin (requests
+// from homeserver) and stdout (responses and notifications from the plugin), one message per line.
+// Everything written to stderr is logged.
+//
+// Methods called by homeserver:
+//
+//	discover                                  -> [{"device": "id", "name": "...", "type": "...", ...}]
+//	setState {"device": "id", "state": {...}} -> null
+//	getState {"device": "id"}                 -> {...}
+//	health                                    -> null
+//
+// Notifications sent by the plugin:
+//
+//	stateChanged {"device": "id", "state": {...}}
+//
+// States use the keys and ranges of the hue api: "on", "bri", "hue", "sat", "ct", "xy" and
+// "colormode". A plugin is restarted with increasing delay when it exits or fails its health check,
+// meanwhile all its devices are unreachable.
+func StartPlugins(ctx context.Context, onDevices func([]Device)) error {
+	names, err := config.Keys(PLUGINS)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		var c pluginConfig
+		if err = config.Load(PLUGINS, name, &c); err != nil {
+			return fmt.Errorf("could not load plugin '%s': %v", name, err)
+		}
+		startPlugin(ctx, name, c, onDevices)
+	}
+	return nil
+}
+
+func startPlugin(ctx context.Context, name string, c pluginConfig, onDevices func([]Device)) *plugin {
+	p := &plugin{
+		name:         name,
+		pluginConfig: c,
+		onDevices:    onDevices,
+		states:       make(map[string]State),
+		notify:       make(map[string]func(State)),
+	}
+	pluginsMu.Lock()
+	plugins[name] = p
+	pluginsMu.Unlock()
+	go p.supervise(ctx)
+	return p
+}
+
+// supervise runs the plugin until ctx is done and restarts it with exponential backoff.
+func (p *plugin) supervise(ctx context.Context) {
+	backoff := pluginMinBackoff
+	for ctx.Err() == nil {
+		started := time.Now()
+		err := p.run(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Since(started) > pluginMaxBackoff {
+			backoff = pluginMinBackoff
+		}
+		log.Printf("ERROR: plugin '%s' stopped: %+v; restarting in %s", p.name, err, backoff)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, pluginMaxBackoff)
+	}
+}
+
+// run starts the plugin process and blocks until it exits.
+func (p *plugin) run(ctx context.Context) error {
+	runCtx, kill := context.WithCancel(ctx)
+	defer kill()
+
+	cmd := exec.CommandContext(runCtx, p.Command, p.Args...)
+	cmd.Env = append(os.Environ(), p.Env...)
+	cmd.Stderr = pluginLog(p.name)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err = cmd.Start(); err != nil {
+		return err
+	}
+	log.Printf("Started plugin '%s' (pid %d)", p.name, cmd.Process.Pid)
+
+	p.mu.Lock()
+	p.stdin = stdin
+	p.kill = kill
+	p.running = true
+	p.pending = make(map[int]chan rpcMessage)
+	p.states = make(map[string]State)
+	p.mu.Unlock()
+
+	go p.health(runCtx, kill)
+	go func() {
+		devices, err := p.discover(runCtx)
+		if err != nil {
+			log.Printf("ERROR: could not discover devices of plugin '%s': %+v", p.name, err)
+			return
+		}
+		if p.onDevices != nil {
+			p.onDevices(devices)
+		}
+	}()
+
+	p.read(stdout)
+	err = cmd.Wait()
+
+	p.mu.Lock()
+	p.running = false
+	for _, ch := range p.pending {
+		close(ch)
+	}
+	p.pending = nil
+	p.mu.Unlock()
+	return err
+}
+
+// pluginLog logs everything a plugin writes to stderr.
+type pluginLog string
+
+func (name pluginLog) Write(b []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimRight(string(b), "\n"), "\n") {
+		log.Printf("plugin '%s': %s", string(name), line)
+	}
+	return len(b), nil
+}
+
+// read handles all messages sent by the plugin until its stdout is closed.
+func (p *plugin) read(stdout io.Reader) {
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var msg rpcMessage
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			log.Printf("ERROR: invalid message from plugin '%s': %+v", p.name, err)
+			continue
+		}
+
+		if msg.ID != nil {
+			p.mu.Lock()
+			ch, ok := p.pending[*msg.ID]
+			delete(p.pending, *msg.ID)
+			p.mu.Unlock()
+			if ok {
+				ch <- msg
+			}
+			continue
+		}
+
+		switch msg.Method {
+		case "stateChanged":
+			var change rpcStateChange
+			buf, _ := json.Marshal(msg.Params)
+			if err := json.Unmarshal(buf, &change); err != nil {
+				log.Printf("ERROR: invalid state change from plugin '%s': %+v", p.name, err)
+				continue
+			}
+			s := change.State.driverState()
+			p.mu.Lock()
+			p.states[change.Device] = s
+			notify := p.notify[change.Device]
+			p.mu.Unlock()
+			if notify != nil {
+				notify(s)
+			}
+		default:
+			log.Printf("ERROR: unknown notification '%s' from plugin '%s'", msg.Method, p.name)
+		}
+	}
+}
+
+// health checks the plugin every pluginHealthInterval and kills it, if it does not answer.
+func (p *plugin) health(ctx context.Context, kill func()) {
+	ticker := time.NewTicker(pluginHealthInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		callCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		err := p.call(callCtx, "health", nil, nil)
+		cancel()
+		if err != nil && ctx.Err() == nil {
+			log.Printf("ERROR: plugin '%s' failed its health check: %+v", p.name, err)
+			kill()
+			return
+		}
+	}
+}
+
+// call calls method of the plugin and stores the result in result (if not nil).
+func (p *plugin) call(ctx context.Context, method string, params, result any) error {
+	p.mu.Lock()
+	if !p.running {
+		p.mu.Unlock()
+		return ErrUnreachable
+	}
+	p.nextID++
+	id := p.nextID
+	ch := make(chan rpcMessage, 1)
+	p.pending[id] = ch
+	stdin, kill := p.stdin, p.kill
+	p.mu.Unlock()
+
+	buf, err := json.Marshal(rpcMessage{Version: "2.0", ID: &id, Method: method, Params: params})
+	if err == nil {
+		err = p.write(ctx, stdin, kill, append(buf, '\n'))
+	}
+	if err != nil {
+		p.mu.Lock()
+		delete(p.pending, id)
+		p.mu.Unlock()
+		return err
+	}
+
+	select {
+	case <-ctx.Done():
+		p.mu.Lock()
+		delete(p.pending, id)
+		p.mu.Unlock()
+		return ctx.Err()
+	case msg, ok := <-ch:
+		if !ok {
+			return ErrUnreachable
+		}
+		if msg.Error != nil {
+			return fmt.Errorf("plugin '%s': %s: %w", p.name, method, msg.Error)
+		}
+		if result == nil || len(msg.Result) == 0 {
+			return nil
+		}
+		return json.Unmarshal(msg.Result, result)
+	}
+}
+
+// write writes msg to stdin of the plugin, waiting at most until ctx is done. A plugin not reading
+// its stdin until the deadline of ctx is killed and restarted, as it would block all callers.
+func (p *plugin) write(ctx context.Context, stdin io.Writer, kill func(), msg []byte) error {
+	written := make(chan error, 1)
+	go func() {
+		p.writeMu.Lock()
+		defer p.writeMu.Unlock()
+		_, err := stdin.Write(msg)
+		written <- err
+	}()
+
+	select {
+	case err := <-written:
+		return err
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			log.Printf("ERROR: plugin '%s' does not read its requests; restarting it", p.name)
+			kill()
+		}
+		return ctx.Err()
+	}
+}
+
+func (p *plugin) discover(ctx context.Context) ([]Device, error) {
+	var resp []rpcDevice
+	if err := p.call(ctx, "discover", nil, &resp); err != nil {
+		return nil, err
+	}
+	devices := make([]Device, len(resp))
+	for i, d := range resp {
+		devices[i] = Device{
+			Name:             d.Name,
+			Type:             d.Type,
+			ModelID:          d.ModelID,
+			ManufacturerName: d.ManufacturerName,
+			ProductName:      d.ProductName,
+			UniqueID:         d.UniqueID,
+			Config:           Config{"type": "plugin", "plugin": p.name, "device": d.Device},
+		}
+	}
+	return devices, nil
+}
+
+func (s rpcState) driverState() State {
+	return State{
+		On:               s.On,
+		Brightness:       s.Brightness,
+		Hue:              s.Hue,
+		Saturation:       s.Saturation,
+		ColorTemperature: s.ColorTemperature,
+		XY:               s.XY,
+		ColorMode:        s.ColorMode,
+	}
+}
+
+func (s State) rpcState() rpcState {
+	return rpcState{
+		On:               s.On,
+		Brightness:       s.Brightness,
+		Hue:              s.Hue,
+		Saturation:       s.Saturation,
+		ColorTemperature: s.ColorTemperature,
+		XY:               s.XY,
+		ColorMode:        s.ColorMode,
+	}
+}
+
+// pluginDriverConfig are the settings of the "plugin" driver.
+//
+//	plugin string the name of the plugin in the plugin file
+//	device string the id of the device within the plugin
+type pluginDriverConfig struct {
+	Plugin string `json:"plugin"`
+	Device string `json:"device"`
+}
+
+// pluginDriver drives a device provided by a plugin.
+type pluginDriver struct {
+	pluginDriverConfig
+}
+
+func newPluginDriver(c Config) (Driver, error) {
+	d := &pluginDriver{}
+	if err := c.Decode(&d.pluginDriverConfig); err != nil {
+		return nil, err
+	}
+	if d.Plugin == "" || d.Device == "" {
+		return nil, fmt.Errorf("plugin: plugin and device are required")
+	}
+	return d, nil
+}
+
+// plugin returns the running plugin of d. The plugin is looked up on every call, as drivers may be
+// created before the plugins are started.
+func (d *pluginDriver) plugin() (*plugin, error) {
+	pluginsMu.RLock()
+	defer pluginsMu.RUnlock()
+	p, ok := plugins[d.Plugin]
+	if !ok {
+		return nil, fmt.Errorf("plugin '%s' is not configured: %w", d.Plugin, ErrUnreachable)
+	}
+	return p, nil
+}
+
+func (d *pluginDriver) SetState(ctx context.Context, s State) error {
+	p, err := d.plugin()
+	if err != nil {
+		return err
+	}
+	err = p.call(ctx, "setState", rpcStateChange{d.Device, s.rpcState()}, nil)
+	if err == nil {
+		p.mu.Lock()
+		p.states[d.Device] = s
+		p.mu.Unlock()
+	}
+	return err
+}
+
+// Notify registers f to be called with every state change the plugin reports for the device.
+func (d *pluginDriver) Notify(f func(State)) {
+	p, err := d.plugin()
+	if err != nil {
+		log.Printf("ERROR: could not subscribe to device '%s': %+v", d.Device, err)
+		return
+	}
+	p.mu.Lock()
+	p.notify[d.Device] = f
+	p.mu.Unlock()
+}
+
+// Probe reports the plugin as unreachable while it is not running.
+func (d *pluginDriver) Probe(ctx context.Context) error {
+	p, err := d.plugin()
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.running {
+		return ErrUnreachable
+	}
+	return nil
+}
+
+// State returns the last state reported by the plugin, or asks the plugin if it has not reported
+// any yet.
+func (d *pluginDriver) State(ctx context.Context) (State, error) {
+	p, err := d.plugin()
+	if err != nil {
+		return State{}, err
+	}
+	p.mu.Lock()
+	s, ok := p.states[d.Device]
+	running := p.running
+	p.mu.Unlock()
+	if !running {
+		return State{}, ErrUnreachable
+	} else if ok {
+		return s, nil
+	}
+
+	var resp rpcState
+	if err = p.call(ctx, "getState", map[string]string{"device": d.Device}, &resp); err != nil {
+		return State{}, err
+	}
+	s = resp.driverState()
+	p.mu.Lock()
+	p.states[d.Device] = s
+	p.mu.Unlock()
+	return s, nil
+}
+
+// pluginDiscoverer asks all running plugins for their devices.
+type pluginDiscoverer struct{}
+
+func (pluginDiscoverer) Discover(ctx context.Context) ([]Device, error) {
+	pluginsMu.RLock()
+	defer pluginsMu.RUnlock()
+
+	var devices []Device
+	for _, p := range plugins {
+		found, err := p.discover(ctx)
+		if err != nil {
+			log.Printf("ERROR: could not discover devices of plugin '%s': %+v", p.name, err)
+			continue
+		}
+		devices = append(devices, found...)
+	}
+	return devices, nil
+}
@@ -0,0 +1,152 @@
+package driver
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+// TestPluginProcess is not a real test, but the plugin started by TestPlugin. It provides the
+// device "lamp" and crashes when the device "crash" is set.
+func TestPluginProcess(t *testing.T) {
+	if os.Getenv("HOMESERVER_TEST_PLUGIN") != "1" {
+		return
+	}
+
+	lamp := rpcState{On: false, Brightness: 1}
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		var req struct {
+			ID     int            `json:"id"`
+			Method string         `json:"method"`
+			Params rpcStateChange `json:"params"`
+		}
+		json.Unmarshal(scanner.Bytes(), &req)
+
+		var result any
+		switch req.Method {
+		case "discover":
+			fmt.Println(`{"jsonrpc": "2.0", "method": "stateChanged", "params": {"device": "lamp", "state": {"on": true, "bri": 42}}}`)
+			result = []rpcDevice{{Device: "lamp", Name: "Plugin Lamp", Type: "Dimmable light", UniqueID: "plugin:lamp"}}
+		case "setState":
+			if req.Params.Device == "crash" {
+				fmt.Fprintln(os.Stderr, "crashing on purpose")
+				os.Exit(1)
+			}
+			lamp = req.Params.State
+		case "getState":
+			result = lamp
+		}
+		buf, _ := json.Marshal(map[string]any{"jsonrpc": "2.0", "id": req.ID, "result": result})
+		fmt.Println(string(buf))
+	}
+	os.Exit(0)
+}
+
+func TestPlugin(t *testing.T) {
+	defer func(d time.Duration) { pluginMinBackoff = d }(pluginMinBackoff)
+	pluginMinBackoff = 100 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	started := make(chan []Device, 2)
+	startPlugin(ctx, "test", pluginConfig{
+		Command: os.Args[0],
+		Args:    []string{"-test.run=^TestPluginProcess$"},
+		Env:     []string{"HOMESERVER_TEST_PLUGIN=1"},
+	}, func(devices []Device) { started <- devices })
+
+	waitStarted := func() {
+		select {
+		case devices := <-started:
+			if len(devices) != 1 || devices[0].Name != "Plugin Lamp" || devices[0].Config["device"] != "lamp" {
+				t.Fatalf("onDevices() want: [Plugin Lamp]; got %+v", devices)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("plugin did not start")
+		}
+	}
+	waitStarted()
+
+	d, err := New(Config{"type": "plugin", "plugin": "test", "device": "lamp"})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	s, err := d.State(ctx)
+	if want := (State{On: true, Brightness: 42}); err != nil || s != want {
+		t.Errorf("State() after stateChanged want: %+v; got %+v, error = %v", want, s, err)
+	}
+	want := State{On: true, Brightness: 200, Hue: 100, Saturation: 200, ColorMode: "hs"}
+	if err = d.SetState(ctx, want); err != nil {
+		t.Fatalf("SetState() error = %v", err)
+	}
+	if s, err = d.State(ctx); err != nil || s != want {
+		t.Errorf("State() want: %+v; got %+v, error = %v", want, s, err)
+	}
+
+	notified := make(chan State, 1)
+	d.(Notifier).Notify(func(s State) { notified <- s })
+
+	crash, _ := New(Config{"type": "plugin", "plugin": "test", "device": "crash"})
+	if err = crash.SetState(ctx, State{}); !errors.Is(err, ErrUnreachable) {
+		t.Errorf("SetState() on crash want: %v; got %v", ErrUnreachable, err)
+	}
+	if _, err = d.State(ctx); !errors.Is(err, ErrUnreachable) {
+		t.Errorf("State() while plugin is down want: %v; got %v", ErrUnreachable, err)
+	}
+
+	waitStarted()
+	select {
+	case s = <-notified:
+		if want := (State{On: true, Brightness: 42}); s != want {
+			t.Errorf("Notify() want: %+v; got %+v", want, s)
+		}
+	default:
+		t.Errorf("Notify() was not called on stateChanged")
+	}
+	if err = d.SetState(ctx, want); err != nil {
+		t.Errorf("SetState() after restart error = %v", err)
+	}
+}
+
+func TestPluginBlockedStdin(t *testing.T) {
+	// nobody reads the pipe, like a plugin that hangs
+	_, stdin := io.Pipe()
+	killed := make(chan struct{}, 2)
+	p := &plugin{
+		name:    "blocked",
+		stdin:   stdin,
+		kill:    func() { killed <- struct{}{} },
+		running: true,
+		pending: make(map[int]chan rpcMessage),
+	}
+
+	for i := 0; i < 2; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		start := time.Now()
+		err := p.call(ctx, "health", nil, nil)
+		cancel()
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("call() want: %v; got %v", context.DeadlineExceeded, err)
+		}
+		if d := time.Since(start); d > time.Second {
+			t.Errorf("call() blocked for %s", d)
+		}
+		select {
+		case <-killed:
+		default:
+			t.Errorf("call() did not kill the plugin")
+		}
+	}
+	if len(p.pending) != 0 {
+		t.Errorf("pending calls want: 0; got %d", len(p.pending))
+	}
+	stdin.Close()
+}
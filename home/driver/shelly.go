@@ -0,0 +1,141 @@
+package driver
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+func init() {
+	Register("shelly", newShelly)
+}
+
+// shellyConfig are the settings of the "shelly" driver.
+//
+//	address  string the ip or url of the Shelly device
+//	gen      int    the device generation: 1 for the http api, 2 for the rpc api. Defaults to 1
+//	channel  int    the relay or light channel to control
+//	mode     string "relay" for switches and plugs, "light" for dimmers. Defaults to "relay"
+//	user     string optional user for basic auth (gen 1 only)
+//	password string optional password for basic auth (gen 1 only)
+type shellyConfig struct {
+	Address  string `json:"address"`
+	Gen      int    `json:"gen,omitempty"`
+	Channel  int    `json:"channel,omitempty"`
+	Mode     string `json:"mode,omitempty"`
+	User     string `json:"user,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+// shelly drives a Shelly relay or dimmer through the gen 1 http api or the gen 2 rpc api.
+type shelly struct {
+	shellyConfig
+}
+
+// shellyStatus is the status of a channel as returned by both the gen 1 and gen 2 api.
+type shellyStatus struct {
+	IsOn       *bool `json:"ison"`
+	Output     *bool `json:"output"`
+	Brightness *int  `json:"brightness"`
+}
+
+func (s shellyStatus) on() bool {
+	if s.IsOn != nil {
+		return *s.IsOn
+	}
+	return s.Output != nil && *s.Output
+}
+
+func newShelly(c Config) (Driver, error) {
+	s := &shelly{shellyConfig{Gen: 1, Mode: "relay"}}
+	if err := c.Decode(&s.shellyConfig); err != nil {
+		return nil, err
+	}
+	if s.Address == "" {
+		return nil, fmt.Errorf("shelly: missing address")
+	}
+	if s.Gen != 1 && s.Gen != 2 {
+		return nil, fmt.Errorf("shelly: unknown generation %d", s.Gen)
+	}
+	if s.Mode != "relay" && s.Mode != "light" {
+		return nil, fmt.Errorf("shelly: unknown mode '%s'", s.Mode)
+	}
+	return s, nil
+}
+
+func (sh *shelly) SetState(ctx context.Context, s State) error {
+	query := url.Values{}
+	if sh.Gen == 1 {
+		query.Set("turn", "off")
+		if s.On {
+			query.Set("turn", "on")
+		}
+	} else {
+		query.Set("id", strconv.Itoa(sh.Channel))
+		query.Set("on", strconv.FormatBool(s.On))
+	}
+	if sh.Mode == "light" && s.On && s.Brightness > 0 {
+		query.Set("brightness", strconv.Itoa(max(ScaleBrightness(s.Brightness, 100), 1)))
+	}
+
+	var status shellyStatus
+	if err := doJSON(ctx, http.MethodGet, sh.url("Set")+"?"+query.Encode(), sh.header(), nil, &status); err != nil {
+		return err
+	}
+	if sh.Gen == 2 {
+		// Switch.Set and Light.Set only report the previous state
+		var err error
+		if status, err = sh.status(ctx); err != nil {
+			return err
+		}
+	}
+	if status.on() != s.On {
+		return fmt.Errorf("shelly: device reports on=%v after switching on=%v", status.on(), s.On)
+	}
+	return nil
+}
+
+func (sh *shelly) State(ctx context.Context) (State, error) {
+	status, err := sh.status(ctx)
+	if err != nil {
+		return State{}, err
+	}
+	s := State{On: status.on()}
+	if status.Brightness != nil {
+		s.Brightness = UnscaleBrightness(*status.Brightness, 100)
+	}
+	return s, nil
+}
+
+func (sh *shelly) status(ctx context.Context) (status shellyStatus, err error) {
+	u := sh.url("GetStatus")
+	if sh.Gen == 2 {
+		u += "?id=" + strconv.Itoa(sh.Channel)
+	}
+	err = doJSON(ctx, http.MethodGet, u, sh.header(), nil, &status)
+	return status, err
+}
+
+// url returns the endpoint of the configured channel. method is the rpc method ("Set" or
+// "GetStatus") and only used for gen 2 devices.
+func (sh *shelly) url(method string) string {
+	if sh.Gen == 1 {
+		return fmt.Sprintf("%s/%s/%d", baseURL(sh.Address), sh.Mode, sh.Channel)
+	}
+	component := "Switch"
+	if sh.Mode == "light" {
+		component = "Light"
+	}
+	return fmt.Sprintf("%s/rpc/%s.%s", baseURL(sh.Address), component, method)
+}
+
+func (sh *shelly) header() http.Header {
+	if sh.Gen != 1 || sh.User == "" {
+		return nil
+	}
+	auth := base64.StdEncoding.EncodeToString([]byte(sh.User + ":" + sh.Password))
+	return http.Header{"Authorization": {"Basic " + auth}}
+}
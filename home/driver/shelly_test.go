@@ -0,0 +1,70 @@
+package driver
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+// fakeShelly implements the light endpoints of a gen 1 and gen 2 Shelly dimmer on channel 0.
+type fakeShelly struct {
+	on         bool
+	brightness int
+}
+
+func (f *fakeShelly) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	switch r.URL.Path {
+	case "/light/0":
+		if turn := q.Get("turn"); turn != "" {
+			f.on = turn == "on"
+		}
+		if b := q.Get("brightness"); b != "" {
+			f.brightness, _ = strconv.Atoi(b)
+		}
+		json.NewEncoder(w).Encode(map[string]any{"ison": f.on, "brightness": f.brightness})
+	case "/rpc/Light.Set":
+		wasOn := f.on
+		f.on, _ = strconv.ParseBool(q.Get("on"))
+		if b := q.Get("brightness"); b != "" {
+			f.brightness, _ = strconv.Atoi(b)
+		}
+		json.NewEncoder(w).Encode(map[string]any{"was_on": wasOn})
+	case "/rpc/Light.GetStatus":
+		json.NewEncoder(w).Encode(map[string]any{"id": 0, "output": f.on, "brightness": f.brightness})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestShelly(t *testing.T) {
+	for _, gen := range []int{1, 2} {
+		t.Run("Gen"+strconv.Itoa(gen), func(t *testing.T) {
+			fake := &fakeShelly{}
+			srv := httptest.NewServer(fake)
+			defer srv.Close()
+
+			d, err := New(Config{"type": "shelly", "address": srv.URL, "gen": gen, "mode": "light"})
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			if err = d.SetState(context.Background(), State{On: true, Brightness: 254}); err != nil {
+				t.Fatalf("SetState() error = %v", err)
+			}
+			if !fake.on || fake.brightness != 100 {
+				t.Errorf("SetState() want: true/100; got %v/%d", fake.on, fake.brightness)
+			}
+
+			s, err := d.State(context.Background())
+			if err != nil {
+				t.Fatalf("State() error = %v", err)
+			}
+			if want := (State{On: true, Brightness: 254}); s != want {
+				t.Errorf("State() want: %+v; got %+v", want, s)
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+func init() {
+	Register("tasmota", newTasmota)
+}
+
+// tasmotaConfig are the settings of the "tasmota" driver.
+//
+//	address  string the ip or url of the Tasmota device
+//	relay    int    the relay to switch on devices with multiple relays; 0 for single relay devices
+//	user     string optional web user
+//	password string optional web password
+type tasmotaConfig struct {
+	Address  string `json:"address"`
+	Relay    int    `json:"relay,omitempty"`
+	User     string `json:"user,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+// tasmota drives a device running Tasmota through its http command api.
+type tasmota struct {
+	tasmotaConfig
+}
+
+func newTasmota(c Config) (Driver, error) {
+	t := &tasmota{}
+	if err := c.Decode(&t.tasmotaConfig); err != nil {
+		return nil, err
+	}
+	if t.Address == "" {
+		return nil, fmt.Errorf("tasmota: missing address")
+	}
+	return t, nil
+}
+
+func (t *tasmota) SetState(ctx context.Context, s State) error {
+	if s.On && s.Brightness > 0 {
+		// setting the dimmer turns the light on as well
+		if _, err := t.command(ctx, fmt.Sprintf("Dimmer %d", ScaleBrightness(s.Brightness, 100))); err != nil {
+			return err
+		}
+	}
+
+	power := "OFF"
+	if s.On {
+		power = "ON"
+	}
+	resp, err := t.command(ctx, fmt.Sprintf("%s %s", t.powerKey(), power))
+	if err != nil {
+		return err
+	}
+	if got := t.power(resp); got != power {
+		return fmt.Errorf("tasmota: device reports power %q after switching %s", got, power)
+	}
+	return nil
+}
+
+func (t *tasmota) State(ctx context.Context) (State, error) {
+	resp, err := t.command(ctx, "State")
+	if err != nil {
+		return State{}, err
+	}
+
+	s := State{On: t.power(resp) == "ON"}
+	if dimmer, ok := resp["Dimmer"].(float64); ok {
+		s.Brightness = UnscaleBrightness(int(dimmer), 100)
+	}
+	return s, nil
+}
+
+// command sends cmnd to the device and returns its json response.
+func (t *tasmota) command(ctx context.Context, cmnd string) (map[string]any, error) {
+	query := url.Values{"cmnd": {cmnd}}
+	if t.User != "" {
+		query.Set("user", t.User)
+		query.Set("password", t.Password)
+	}
+
+	resp := make(map[string]any)
+	err := doJSON(ctx, http.MethodGet, baseURL(t.Address)+"/cm?"+query.Encode(), nil, nil, &resp)
+	return resp, err
+}
+
+func (t *tasmota) powerKey() string {
+	if t.Relay > 0 {
+		return fmt.Sprintf("Power%d", t.Relay)
+	}
+	return "Power"
+}
+
+// power returns the reported power state ("ON" or "OFF") of the configured relay in resp.
+func (t *tasmota) power(resp map[string]any) string {
+	if v, ok := resp[strings.ToUpper(t.powerKey())].(string); ok {
+		return v
+	}
+	// single relay devices may answer "POWER" to "Power1" and the other way round
+	for _, key := range []string{"POWER", "POWER1"} {
+		if v, ok := resp[key].(string); ok {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,77 @@
+package driver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// fakeTasmota answers the Power, Dimmer and State commands of a Tasmota dimmer.
+type fakeTasmota struct {
+	power  string
+	dimmer int
+}
+
+func (f *fakeTasmota) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	cmnd := strings.Fields(r.URL.Query().Get("cmnd"))
+	if r.URL.Path != "/cm" || len(cmnd) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	switch {
+	case strings.EqualFold(cmnd[0], "Power") && len(cmnd) == 2:
+		f.power = strings.ToUpper(cmnd[1])
+	case strings.EqualFold(cmnd[0], "Dimmer") && len(cmnd) == 2:
+		f.dimmer, _ = strconv.Atoi(cmnd[1])
+		f.power = "ON"
+	case strings.EqualFold(cmnd[0], "State"):
+	default:
+		fmt.Fprint(w, `{"Command":"Unknown"}`)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]any{"POWER": f.power, "Dimmer": f.dimmer})
+}
+
+func TestTasmota(t *testing.T) {
+	tests := []struct {
+		name       string
+		state      State
+		wantPower  string
+		wantDimmer int
+	}{
+		{"On", State{On: true}, "ON", 0},
+		{"Dimmed", State{On: true, Brightness: 127}, "ON", 50},
+		{"Off", State{On: false, Brightness: 254}, "OFF", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeTasmota{power: "OFF"}
+			srv := httptest.NewServer(fake)
+			defer srv.Close()
+
+			d, err := New(Config{"type": "tasmota", "address": srv.URL})
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			if err = d.SetState(context.Background(), tt.state); err != nil {
+				t.Fatalf("SetState() error = %v", err)
+			}
+			if fake.power != tt.wantPower || fake.dimmer != tt.wantDimmer {
+				t.Errorf("SetState() want: %s/%d; got %s/%d", tt.wantPower, tt.wantDimmer, fake.power, fake.dimmer)
+			}
+
+			s, err := d.State(context.Background())
+			if err != nil {
+				t.Fatalf("State() error = %v", err)
+			}
+			if s.On != tt.state.On {
+				t.Errorf("State() on want: %v; got %v", tt.state.On, s.On)
+			}
+		})
+	}
+}
@@ -0,0 +1,173 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+func init() {
+	Register("wled", newWLED)
+	RegisterDiscoverer("wled", wledDiscoverer{})
+}
+
+const (
+	wledMinKelvin = 1900
+	wledMaxKelvin = 10091
+)
+
+// wledConfig are the settings of the "wled" driver.
+//
+//	address string the ip or url of the WLED instance
+//	segment *int   the segment to control; if not set all selected segments are controlled
+//	cct     bool   send color temperatures as cct instead of converting them to rgb
+type wledConfig struct {
+	Address string `json:"address"`
+	Segment *int   `json:"segment,omitempty"`
+	CCT     bool   `json:"cct,omitempty"`
+}
+
+// wled drives a WLED instance through its json api.
+type wled struct {
+	wledConfig
+}
+
+type wledState struct {
+	On  *bool         `json:"on,omitempty"`
+	Bri *int          `json:"bri,omitempty"`
+	Seg []wledSegment `json:"seg,omitempty"`
+}
+
+type wledSegment struct {
+	ID  *int    `json:"id,omitempty"`
+	Col [][]int `json:"col,omitempty"`
+	CCT *int    `json:"cct,omitempty"`
+}
+
+type wledInfo struct {
+	Name    string `json:"name"`
+	Version string `json:"ver"`
+	MAC     string `json:"mac"`
+	Brand   string `json:"brand"`
+	Product string `json:"product"`
+}
+
+func newWLED(c Config) (Driver, error) {
+	w := &wled{}
+	if err := c.Decode(&w.wledConfig); err != nil {
+		return nil, err
+	}
+	if w.Address == "" {
+		return nil, fmt.Errorf("wled: missing address")
+	}
+	return w, nil
+}
+
+func (w *wled) SetState(ctx context.Context, s State) error {
+	req := wledState{On: &s.On}
+	if s.Brightness > 0 {
+		bri := ScaleBrightness(s.Brightness, 255)
+		req.Bri = &bri
+	}
+
+	seg := wledSegment{ID: w.Segment}
+	if s.ColorMode == "ct" && w.CCT {
+		kelvin := MiredToKelvin(s.ColorTemperature)
+		seg.CCT = &kelvin
+	} else if s.ColorMode != "" {
+		r, g, b := s.RGB()
+		seg.Col = [][]int{{int(r), int(g), int(b)}}
+	}
+	if seg.Col != nil || seg.CCT != nil {
+		req.Seg = []wledSegment{seg}
+	}
+
+	return doJSON(ctx, http.MethodPost, baseURL(w.Address)+"/json/state", nil, req, nil)
+}
+
+func (w *wled) State(ctx context.Context) (State, error) {
+	var resp wledState
+	if err := doJSON(ctx, http.MethodGet, baseURL(w.Address)+"/json/state", nil, nil, &resp); err != nil {
+		return State{}, err
+	}
+
+	var s State
+	if resp.On != nil {
+		s.On = *resp.On
+	}
+	if resp.Bri != nil {
+		s.Brightness = UnscaleBrightness(*resp.Bri, 255)
+	}
+
+	seg := w.segment(resp.Seg)
+	if seg == nil {
+		return s, nil
+	}
+	if w.CCT && seg.CCT != nil && *seg.CCT > 0 {
+		kelvin := *seg.CCT
+		if kelvin <= 255 {
+			// relative value from 0 (warm) to 255 (cold)
+			kelvin = wledMinKelvin + kelvin*(wledMaxKelvin-wledMinKelvin)/255
+		}
+		s.ColorMode = "ct"
+		s.ColorTemperature = KelvinToMired(kelvin)
+	} else if len(seg.Col) > 0 && len(seg.Col[0]) >= 3 {
+		s.ColorMode = "hs"
+		s.Hue, s.Saturation = RGBToHS(uint8(seg.Col[0][0]), uint8(seg.Col[0][1]), uint8(seg.Col[0][2]))
+	}
+	return s, nil
+}
+
+// segment returns the configured segment out of segs, or the first one if no segment is configured.
+func (w *wled) segment(segs []wledSegment) *wledSegment {
+	for i, seg := range segs {
+		if w.Segment == nil || (seg.ID != nil && *seg.ID == *w.Segment) {
+			return &segs[i]
+		}
+	}
+	return nil
+}
+
+// wledDiscoverer finds WLED instances via mDNS.
+type wledDiscoverer struct{}
+
+func (wledDiscoverer) Discover(ctx context.Context) ([]Device, error) {
+	browseCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	services, err := browseMDNS(browseCtx, "_wled._tcp.local.")
+	if err != nil {
+		return nil, err
+	}
+
+	devices := make([]Device, 0, len(services))
+	for _, s := range services {
+		address := fmt.Sprintf("%s:%d", s.Addr, s.Port)
+		var info wledInfo
+		if err = doJSON(ctx, http.MethodGet, baseURL(address)+"/json/info", nil, nil, &info); err != nil {
+			log.Printf("ERROR: could not get info of WLED instance %s: %+v", address, err)
+			continue
+		}
+		devices = append(devices, Device{
+			Name:             info.Name,
+			Type:             "Extended color light",
+			ManufacturerName: info.Brand,
+			ProductName:      info.Product,
+			UniqueID:         formatMAC(info.MAC) + "-0b",
+			Config:           Config{"type": "wled", "address": address},
+		})
+	}
+	return devices, nil
+}
+
+// formatMAC formats a mac address without separators (e.g. "aabbccddeeff") as
+// "aa:bb:cc:dd:ee:ff".
+func formatMAC(mac string) string {
+	mac = strings.ToLower(strings.NewReplacer(":", "", "-", "").Replace(mac))
+	var parts []string
+	for i := 0; i+2 <= len(mac); i += 2 {
+		parts = append(parts, mac[i:i+2])
+	}
+	return strings.Join(parts, ":")
+}
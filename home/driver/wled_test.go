@@ -0,0 +1,129 @@
+package driver
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// fakeWLED is a minimal WLED json api keeping its state in memory.
+type fakeWLED struct {
+	mu  sync.Mutex
+	on  bool
+	bri int
+	col []int
+	cct int
+}
+
+func (f *fakeWLED) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r.URL.Path != "/json/state" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		var req wledState
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if req.On != nil {
+			f.on = *req.On
+		}
+		if req.Bri != nil {
+			f.bri = *req.Bri
+		}
+		for _, seg := range req.Seg {
+			if len(seg.Col) > 0 {
+				f.col = seg.Col[0]
+			}
+			if seg.CCT != nil {
+				f.cct = *seg.CCT
+			}
+		}
+	}
+
+	id := 0
+	json.NewEncoder(w).Encode(wledState{
+		On:  &f.on,
+		Bri: &f.bri,
+		Seg: []wledSegment{{ID: &id, Col: [][]int{f.col, {0, 0, 0}, {0, 0, 0}}, CCT: &f.cct}},
+	})
+}
+
+func TestWLEDSetState(t *testing.T) {
+	tests := []struct {
+		name    string
+		cct     bool
+		state   State
+		wantBri int
+		wantCol []int
+		wantCCT int
+	}{
+		{"Red", false, State{On: true, Brightness: 254, Hue: 0, Saturation: 254, ColorMode: "hs"}, 255, []int{255, 0, 0}, 0},
+		{"Green", false, State{On: true, Brightness: 127, Hue: 21845, Saturation: 254, ColorMode: "hs"}, 128, []int{0, 255, 0}, 0},
+		{"White", false, State{On: true, Brightness: 1, Saturation: 0, ColorMode: "hs"}, 1, []int{255, 255, 255}, 0},
+		{"Warm as rgb", false, State{On: true, Brightness: 254, ColorTemperature: 500, ColorMode: "ct"}, 255, []int{255, 137, 14}, 0},
+		{"Warm as cct", true, State{On: true, Brightness: 254, ColorTemperature: 500, ColorMode: "ct"}, 255, nil, 2000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeWLED{}
+			srv := httptest.NewServer(fake)
+			defer srv.Close()
+
+			d, err := New(Config{"type": "wled", "address": srv.URL, "cct": tt.cct})
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			if err = d.SetState(context.Background(), tt.state); err != nil {
+				t.Fatalf("SetState() error = %v", err)
+			}
+			if !fake.on || fake.bri != tt.wantBri {
+				t.Errorf("SetState() on/bri want: true/%d; got %v/%d", tt.wantBri, fake.on, fake.bri)
+			}
+			if tt.wantCol != nil && !equalInts(fake.col, tt.wantCol) {
+				t.Errorf("SetState() col want: %v; got %v", tt.wantCol, fake.col)
+			}
+			if fake.cct != tt.wantCCT {
+				t.Errorf("SetState() cct want: %d; got %d", tt.wantCCT, fake.cct)
+			}
+		})
+	}
+}
+
+func TestWLEDState(t *testing.T) {
+	fake := &fakeWLED{on: true, bri: 255, col: []int{0, 0, 255}}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	d, err := New(Config{"type": "wled", "address": srv.URL, "segment": 0})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	s, err := d.State(context.Background())
+	if err != nil {
+		t.Fatalf("State() error = %v", err)
+	}
+	want := State{On: true, Brightness: 254, Hue: 43690, Saturation: 254, ColorMode: "hs"}
+	if s != want {
+		t.Errorf("State() want: %+v; got %+v", want, s)
+	}
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
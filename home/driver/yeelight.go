@@ -0,0 +1,239 @@
+package driver
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	Register("yeelight", newYeelight)
+	RegisterDiscoverer("yeelight", yeelightDiscoverer{})
+}
+
+const yeelightPort = 55443
+
+// yeelightSearchAddr is where search requests are sent to during discovery.
+var yeelightSearchAddr = &net.UDPAddr{IP: net.IPv4(239, 255, 255, 250), Port: 1982}
+
+// yeelightConfig are the settings of the "yeelight" driver.
+//
+//	address string the ip of the bulb, optionally with port
+type yeelightConfig struct {
+	Address string `json:"address"`
+}
+
+// yeelight drives a Yeelight bulb using its json protocol over tcp.
+type yeelight struct {
+	yeelightConfig
+}
+
+type yeelightCommand struct {
+	ID     int    `json:"id"`
+	Method string `json:"method"`
+	Params []any  `json:"params"`
+}
+
+type yeelightResult struct {
+	ID     int      `json:"id"`
+	Method string   `json:"method,omitempty"`
+	Result []string `json:"result,omitempty"`
+	Error  *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+func newYeelight(c Config) (Driver, error) {
+	y := &yeelight{}
+	if err := c.Decode(&y.yeelightConfig); err != nil {
+		return nil, err
+	}
+	if y.Address == "" {
+		return nil, fmt.Errorf("yeelight: missing address")
+	}
+	return y, nil
+}
+
+func (y *yeelight) SetState(ctx context.Context, s State) error {
+	if !s.On {
+		_, err := y.call(ctx, yeelightCommand{Method: "set_power", Params: []any{"off", "sudden", 0}})
+		return err
+	}
+
+	cmds := []yeelightCommand{{Method: "set_power", Params: []any{"on", "sudden", 0}}}
+	if s.Brightness > 0 {
+		cmds = append(cmds, yeelightCommand{Method: "set_bright", Params: []any{max(ScaleBrightness(s.Brightness, 100), 1), "sudden", 0}})
+	}
+	switch s.ColorMode {
+	case "hs", "xy":
+		hue, sat := s.Hue, s.Saturation
+		if s.ColorMode == "xy" {
+			hue, sat = RGBToHS(XYToRGB(s.XY[0], s.XY[1]))
+		}
+		cmds = append(cmds, yeelightCommand{Method: "set_hsv", Params: []any{hue * 359 / 65535, ScaleBrightness(sat, 100), "sudden", 0}})
+	case "ct":
+		kelvin := min(max(MiredToKelvin(s.ColorTemperature), 1700), 6500)
+		cmds = append(cmds, yeelightCommand{Method: "set_ct_abx", Params: []any{kelvin, "sudden", 0}})
+	}
+	_, err := y.call(ctx, cmds...)
+	return err
+}
+
+func (y *yeelight) State(ctx context.Context) (State, error) {
+	results, err := y.call(ctx, yeelightCommand{Method: "get_prop", Params: []any{"power", "bright", "ct", "hue", "sat", "color_mode", "rgb"}})
+	if err != nil {
+		return State{}, err
+	}
+	props := results[0].Result
+	if len(props) != 7 {
+		return State{}, fmt.Errorf("yeelight: unexpected properties %v", props)
+	}
+	value := func(i int) int {
+		v, _ := strconv.Atoi(props[i])
+		return v
+	}
+
+	s := State{On: props[0] == "on", Brightness: UnscaleBrightness(value(1), 100)}
+	switch props[5] {
+	case "2":
+		s.ColorMode = "ct"
+		s.ColorTemperature = KelvinToMired(value(2))
+	case "1":
+		// hue and sat are stale in rgb mode
+		rgb := value(6)
+		s.ColorMode = "hs"
+		s.Hue, s.Saturation = RGBToHS(uint8(rgb>>16), uint8(rgb>>8), uint8(rgb))
+	case "3":
+		s.ColorMode = "hs"
+		s.Hue = value(3) * 65535 / 359
+		s.Saturation = value(4) * 254 / 100
+	}
+	return s, nil
+}
+
+// call sends cmds over a single connection and returns their results in the same order.
+func (y *yeelight) call(ctx context.Context, cmds ...yeelightCommand) ([]yeelightResult, error) {
+	address := y.Address
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, strconv.Itoa(yeelightPort))
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+
+	reader := bufio.NewReader(conn)
+	results := make([]yeelightResult, len(cmds))
+	for i, cmd := range cmds {
+		cmd.ID = i + 1
+		buf, err := json.Marshal(cmd)
+		if err != nil {
+			return nil, err
+		}
+		if _, err = conn.Write(append(buf, "\r\n"...)); err != nil {
+			return nil, err
+		}
+
+		for {
+			line, err := reader.ReadBytes('\n')
+			if err != nil {
+				return nil, err
+			}
+			var r yeelightResult
+			if err = json.Unmarshal(line, &r); err != nil {
+				return nil, fmt.Errorf("yeelight: invalid response: %v", err)
+			}
+			// skip notifications about property changes
+			if r.Method != "" || r.ID != cmd.ID {
+				continue
+			}
+			if r.Error != nil {
+				return nil, fmt.Errorf("yeelight: %s failed: %s (%d)", cmd.Method, r.Error.Message, r.Error.Code)
+			}
+			results[i] = r
+			break
+		}
+	}
+	return results, nil
+}
+
+// yeelightDiscoverer finds Yeelight bulbs by their ssdp like search on port 1982.
+type yeelightDiscoverer struct{}
+
+func (yeelightDiscoverer) Discover(ctx context.Context) ([]Device, error) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	search := fmt.Sprintf("M-SEARCH * HTTP/1.1\r\nHOST: %s\r\nMAN: \"ssdp:discover\"\r\nST: wifi_bulb\r\n\r\n", yeelightSearchAddr)
+	if _, err = conn.WriteTo([]byte(search), yeelightSearchAddr); err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetReadDeadline(deadline)
+
+	found := make(map[string]Device)
+	buf := make([]byte, 2048)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			break
+		}
+		resp, err := http.ReadResponse(bufio.NewReader(strings.NewReader(string(buf[:n])+"\r\n")), nil)
+		if err != nil {
+			continue
+		}
+		resp.Body.Close()
+		id := resp.Header.Get("id")
+		address := strings.TrimPrefix(resp.Header.Get("Location"), "yeelight://")
+		if id == "" || address == "" {
+			continue
+		}
+
+		typ := "Extended color light"
+		switch resp.Header.Get("model") {
+		case "mono", "mono1", "ct_bulb":
+			typ = "Dimmable light"
+			if strings.Contains(resp.Header.Get("support"), "set_ct_abx") {
+				typ = "Color temperature light"
+			}
+		}
+		name := resp.Header.Get("name")
+		if name == "" {
+			name = "Yeelight " + id
+		}
+		found[id] = Device{
+			Name:             name,
+			Type:             typ,
+			ManufacturerName: "Yeelight",
+			UniqueID:         "yeelight:" + id,
+			Config:           Config{"type": "yeelight", "address": address},
+		}
+	}
+
+	devices := make([]Device, 0, len(found))
+	for _, d := range found {
+		devices = append(devices, d)
+	}
+	return devices, nil
+}
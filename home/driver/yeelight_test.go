@@ -0,0 +1,153 @@
+package driver
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeYeelight is a Yeelight bulb listening on loopback for commands (tcp) and searches (udp).
+type fakeYeelight struct {
+	listener net.Listener
+	search   *net.UDPConn
+
+	mu    sync.Mutex
+	props map[string]string
+}
+
+func newFakeYeelight(t *testing.T) *fakeYeelight {
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %v", err)
+	}
+	search, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("could not listen: %v", err)
+	}
+	t.Cleanup(func() {
+		listener.Close()
+		search.Close()
+	})
+
+	f := &fakeYeelight{listener: listener, search: search, props: map[string]string{
+		"power": "off", "bright": "100", "ct": "4000", "hue": "0", "sat": "0", "color_mode": "2", "rgb": "16777215",
+	}}
+	go f.serveSearch()
+	go f.serve()
+	return f
+}
+
+func (f *fakeYeelight) serveSearch() {
+	buf := make([]byte, 1024)
+	for {
+		n, addr, err := f.search.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		if !strings.Contains(string(buf[:n]), "ST: wifi_bulb") {
+			continue
+		}
+		resp := fmt.Sprintf("HTTP/1.1 200 OK\r\nCache-Control: max-age=3600\r\nLocation: yeelight://%s\r\n"+
+			"id: 0x000000000015243f\r\nmodel: color\r\nsupport: get_prop set_power set_bright set_ct_abx set_hsv\r\nname: Bedroom\r\n",
+			f.listener.Addr())
+		f.search.WriteToUDP([]byte(resp), addr)
+	}
+}
+
+func (f *fakeYeelight) serve() {
+	for {
+		conn, err := f.listener.Accept()
+		if err != nil {
+			return
+		}
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			var cmd yeelightCommand
+			json.Unmarshal(scanner.Bytes(), &cmd)
+			f.mu.Lock()
+			result := []string{"ok"}
+			switch cmd.Method {
+			case "set_power":
+				f.props["power"] = cmd.Params[0].(string)
+			case "set_bright":
+				f.props["bright"] = fmt.Sprint(cmd.Params[0])
+			case "set_ct_abx":
+				f.props["ct"] = fmt.Sprint(cmd.Params[0])
+				f.props["color_mode"] = "2"
+			case "set_hsv":
+				f.props["hue"] = fmt.Sprint(cmd.Params[0])
+				f.props["sat"] = fmt.Sprint(cmd.Params[1])
+				f.props["color_mode"] = "3"
+			case "get_prop":
+				result = nil
+				for _, p := range cmd.Params {
+					result = append(result, f.props[p.(string)])
+				}
+			}
+			// a notification is sent before each result, like real bulbs do
+			fmt.Fprintf(conn, "{\"method\":\"props\",\"params\":{\"power\":%q}}\r\n", f.props["power"])
+			f.mu.Unlock()
+			buf, _ := json.Marshal(yeelightResult{ID: cmd.ID, Result: result})
+			conn.Write(append(buf, "\r\n"...))
+		}
+		conn.Close()
+	}
+}
+
+func TestYeelight(t *testing.T) {
+	fake := newFakeYeelight(t)
+	defer func(addr *net.UDPAddr) { yeelightSearchAddr = addr }(yeelightSearchAddr)
+	yeelightSearchAddr = fake.search.LocalAddr().(*net.UDPAddr)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	devices, err := yeelightDiscoverer{}.Discover(ctx)
+	if err != nil {
+		t.Fatalf("Discover() error = %v", err)
+	}
+	if len(devices) != 1 || devices[0].Name != "Bedroom" || devices[0].Config["address"] != fake.listener.Addr().String() {
+		t.Fatalf("Discover() want: [Bedroom]; got %+v", devices)
+	}
+
+	d, err := New(devices[0].Config)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	ctx = context.Background()
+	if err = d.SetState(ctx, State{On: true, Brightness: 127, Hue: 32768, Saturation: 254, ColorMode: "hs"}); err != nil {
+		t.Fatalf("SetState() error = %v", err)
+	}
+	fake.mu.Lock()
+	if fake.props["power"] != "on" || fake.props["bright"] != "50" || fake.props["hue"] != "179" || fake.props["sat"] != "100" {
+		t.Errorf("SetState() want: on 50 179 100; got %v", fake.props)
+	}
+	fake.mu.Unlock()
+
+	if err = d.SetState(ctx, State{On: true, Brightness: 254, ColorTemperature: 250, ColorMode: "ct"}); err != nil {
+		t.Fatalf("SetState() error = %v", err)
+	}
+	s, err := d.State(ctx)
+	if err != nil {
+		t.Fatalf("State() error = %v", err)
+	}
+	if want := (State{On: true, Brightness: 254, ColorTemperature: 250, ColorMode: "ct"}); s != want {
+		t.Errorf("State() want: %+v; got %+v", want, s)
+	}
+
+	// set to red by the app, hue and sat keep the last hsv color
+	fake.mu.Lock()
+	fake.props["color_mode"], fake.props["rgb"] = "1", "16711680"
+	fake.mu.Unlock()
+	if s, err = d.State(ctx); err != nil {
+		t.Fatalf("State() error = %v", err)
+	}
+	if s.ColorMode != "hs" || s.Hue != 0 || s.Saturation != 254 {
+		t.Errorf("State() in rgb mode want: hs 0 254; got %v %d %d", s.ColorMode, s.Hue, s.Saturation)
+	}
+}
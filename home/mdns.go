@@ -0,0 +1,266 @@
+package home
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+	"golang.org/x/net/ipv4"
+)
+
+var mdnsGroup = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+const (
+	mdnsService  = "_hue._tcp.local."
+	mdnsServices = "_services._dns-sd._udp.local."
+	// mdnsModelID is the model of the bridge announced in the txt record, the square Hue bridge.
+	mdnsModelID = "BSB002"
+	// mdnsCacheFlush marks records in multicast responses as the only ones with their name.
+	mdnsCacheFlush = 1 << 15
+)
+
+// MDNSConfig configures an MDNSResponder.
+type MDNSConfig struct {
+	// Interface sends and receives the messages, or all multicast interfaces if nil.
+	Interface *net.Interface
+	// Group is the multicast address, 224.0.0.251:5353 if nil.
+	Group *net.UDPAddr
+	// IP and Port are the address of the webserver.
+	IP   net.IP
+	Port int
+	// MAC identifies the bridge.
+	MAC net.HardwareAddr
+	// TTL is how many seconds the records are valid, 120 if not set.
+	TTL uint32
+}
+
+// MDNSResponder announces the bridge as _hue._tcp service by mDNS, with the bridgeid and modelid txt
+// records, like a Hue bridge.
+type MDNSResponder struct {
+	cfg      MDNSConfig
+	instance dnsmessage.Name
+	host     dnsmessage.Name
+	service  dnsmessage.Name
+	services dnsmessage.Name
+	conn     *ipv4.PacketConn
+	done     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// NewMDNSResponder joins the multicast group and starts responding. The records are announced
+// right away and once more a second later.
+func NewMDNSResponder(cfg MDNSConfig) (*MDNSResponder, error) {
+	if cfg.Group == nil {
+		cfg.Group = mdnsGroup
+	}
+	if cfg.TTL == 0 {
+		cfg.TTL = 120
+	}
+	if cfg.IP.To4() == nil {
+		return nil, fmt.Errorf("no ipv4 address to announce: %v", cfg.IP)
+	}
+
+	id := BridgeID(cfg.MAC)
+	r := &MDNSResponder{cfg: cfg, done: make(chan struct{})}
+	var err error
+	if r.instance, err = dnsmessage.NewName("Philips Hue - " + id[len(id)-6:] + "." + mdnsService); err != nil {
+		return nil, err
+	}
+	if r.host, err = dnsmessage.NewName("hue-" + BridgeSerial(cfg.MAC) + ".local."); err != nil {
+		return nil, err
+	}
+	r.service = dnsmessage.MustNewName(mdnsService)
+	r.services = dnsmessage.MustNewName(mdnsServices)
+
+	c, err := net.ListenUDP("udp4", cfg.Group)
+	if err != nil {
+		return nil, err
+	}
+	r.conn = ipv4.NewPacketConn(c)
+	if err = joinGroup(r.conn, cfg.Interface, cfg.Group); err != nil {
+		r.conn.Close()
+		return nil, err
+	}
+	r.conn.SetMulticastLoopback(true)
+	if cfg.Interface != nil {
+		if err = r.conn.SetMulticastInterface(cfg.Interface); err != nil {
+			r.conn.Close()
+			return nil, err
+		}
+	}
+
+	r.wg.Add(2)
+	go r.receive()
+	go r.announce()
+	return r, nil
+}
+
+// Close sends a goodbye, so the records are removed from caches, and stops responding.
+func (r *MDNSResponder) Close() error {
+	msg := dnsmessage.Message{
+		Header:  dnsmessage.Header{Response: true, Authoritative: true},
+		Answers: r.records(0, true, dnsmessage.TypePTR),
+	}
+	err := r.send(msg, r.cfg.Group)
+	close(r.done)
+	err = errors.Join(err, r.conn.Close())
+	r.wg.Wait()
+	return err
+}
+
+func (r *MDNSResponder) announce() {
+	defer r.wg.Done()
+	for i := 0; i < 2; i++ {
+		msg := dnsmessage.Message{
+			Header:  dnsmessage.Header{Response: true, Authoritative: true},
+			Answers: r.records(r.cfg.TTL, true, dnsmessage.TypeALL),
+		}
+		if err := r.send(msg, r.cfg.Group); err != nil {
+			log.Printf("ERROR: could not announce by mdns: %+v", err)
+		}
+		select {
+		case <-r.done:
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+func (r *MDNSResponder) receive() {
+	defer r.wg.Done()
+	buf := make([]byte, 9000)
+	for {
+		n, _, from, err := r.conn.ReadFrom(buf)
+		if err != nil {
+			select {
+			case <-r.done:
+			default:
+				log.Printf("ERROR: could not receive mdns messages: %+v", err)
+			}
+			return
+		}
+		var query dnsmessage.Message
+		if err = query.Unpack(buf[:n]); err != nil || query.Response {
+			continue
+		}
+		r.answer(query, from)
+	}
+}
+
+// answer responds to the questions of query about the bridge. Queries not sent from the mdns port
+// are answered directly, as the sender only waits for a unicast response.
+func (r *MDNSResponder) answer(query dnsmessage.Message, from net.Addr) {
+	udp, _ := from.(*net.UDPAddr)
+	legacy := udp != nil && udp.Port != r.cfg.Group.Port
+	unicast := legacy
+
+	resp := dnsmessage.Message{Header: dnsmessage.Header{Response: true, Authoritative: true}}
+	for _, q := range query.Questions {
+		answers, additionals := r.lookup(q, !legacy)
+		if len(answers) == 0 {
+			continue
+		}
+		if q.Class&mdnsCacheFlush != 0 {
+			// the question asks for a unicast response
+			unicast = true
+		}
+		resp.Answers = append(resp.Answers, answers...)
+		resp.Additionals = append(resp.Additionals, additionals...)
+	}
+	if len(resp.Answers) == 0 {
+		return
+	}
+	if legacy {
+		resp.ID = query.ID
+		resp.Questions = query.Questions
+	}
+
+	to := net.Addr(r.cfg.Group)
+	if unicast && udp != nil {
+		to = udp
+	}
+	if err := r.send(resp, to); err != nil {
+		log.Printf("ERROR: could not answer mdns query from %s: %+v", from, err)
+	}
+}
+
+// lookup returns the records answering q and the additional records the asker needs next.
+func (r *MDNSResponder) lookup(q dnsmessage.Question, flush bool) (answers, additionals []dnsmessage.Resource) {
+	name := strings.ToLower(q.Name.String())
+	matches := func(t dnsmessage.Type) bool {
+		return q.Type == t || q.Type == dnsmessage.TypeALL
+	}
+	switch {
+	case name == strings.ToLower(r.service.String()) && matches(dnsmessage.TypePTR):
+		return r.records(r.cfg.TTL, flush, dnsmessage.TypePTR),
+			r.records(r.cfg.TTL, flush, dnsmessage.TypeSRV, dnsmessage.TypeTXT, dnsmessage.TypeA)
+	case name == strings.ToLower(r.services.String()) && matches(dnsmessage.TypePTR):
+		return []dnsmessage.Resource{{
+			Header: dnsmessage.ResourceHeader{Name: r.services, Type: dnsmessage.TypePTR, Class: dnsmessage.ClassINET, TTL: r.cfg.TTL},
+			Body:   &dnsmessage.PTRResource{PTR: r.service},
+		}}, nil
+	case name == strings.ToLower(r.instance.String()):
+		var types []dnsmessage.Type
+		for _, t := range []dnsmessage.Type{dnsmessage.TypeSRV, dnsmessage.TypeTXT} {
+			if matches(t) {
+				types = append(types, t)
+			}
+		}
+		return r.records(r.cfg.TTL, flush, types...), r.records(r.cfg.TTL, flush, dnsmessage.TypeA)
+	case name == strings.ToLower(r.host.String()) && matches(dnsmessage.TypeA):
+		return r.records(r.cfg.TTL, flush, dnsmessage.TypeA), nil
+	}
+	return nil, nil
+}
+
+// records returns the records of the bridge of the given types, or all for dnsmessage.TypeALL.
+// flush sets the cache flush bit of the records unique to the bridge.
+func (r *MDNSResponder) records(ttl uint32, flush bool, types ...dnsmessage.Type) []dnsmessage.Resource {
+	header := func(name dnsmessage.Name, t dnsmessage.Type, unique bool) dnsmessage.ResourceHeader {
+		class := dnsmessage.ClassINET
+		if unique && flush {
+			class |= mdnsCacheFlush
+		}
+		return dnsmessage.ResourceHeader{Name: name, Type: t, Class: class, TTL: ttl}
+	}
+	var ip [4]byte
+	copy(ip[:], r.cfg.IP.To4())
+
+	var rs []dnsmessage.Resource
+	for _, t := range types {
+		all := t == dnsmessage.TypeALL
+		if all || t == dnsmessage.TypePTR {
+			rs = append(rs, dnsmessage.Resource{Header: header(r.service, dnsmessage.TypePTR, false),
+				Body: &dnsmessage.PTRResource{PTR: r.instance}})
+		}
+		if all || t == dnsmessage.TypeSRV {
+			rs = append(rs, dnsmessage.Resource{Header: header(r.instance, dnsmessage.TypeSRV, true),
+				Body: &dnsmessage.SRVResource{Target: r.host, Port: uint16(r.cfg.Port)}})
+		}
+		if all || t == dnsmessage.TypeTXT {
+			rs = append(rs, dnsmessage.Resource{Header: header(r.instance, dnsmessage.TypeTXT, true),
+				Body: &dnsmessage.TXTResource{TXT: []string{
+					"bridgeid=" + strings.ToLower(BridgeID(r.cfg.MAC)),
+					"modelid=" + mdnsModelID,
+				}}})
+		}
+		if all || t == dnsmessage.TypeA {
+			rs = append(rs, dnsmessage.Resource{Header: header(r.host, dnsmessage.TypeA, true),
+				Body: &dnsmessage.AResource{A: ip}})
+		}
+	}
+	return rs
+}
+
+func (r *MDNSResponder) send(msg dnsmessage.Message, to net.Addr) error {
+	buf, err := msg.Pack()
+	if err != nil {
+		return err
+	}
+	_, err = r.conn.WriteTo(buf, nil, to)
+	return err
+}
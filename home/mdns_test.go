@@ -0,0 +1,165 @@
+package home
+
+import (
+	"net"
+	"sort"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+	"golang.org/x/net/ipv4"
+)
+
+func readMDNS(t *testing.T, conn net.PacketConn, timeout time.Duration) []dnsmessage.Message {
+	t.Helper()
+	var msgs []dnsmessage.Message
+	buf := make([]byte, 9000)
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return msgs
+		}
+		var msg dnsmessage.Message
+		if err = msg.Unpack(buf[:n]); err != nil {
+			t.Fatalf("invalid mdns message: %v", err)
+		}
+		if msg.Response {
+			msgs = append(msgs, msg)
+		}
+	}
+}
+
+// summary describes the records of msg sorted, like "TXT Philips Hue - 100491._hue._tcp.local.".
+func summary(msg dnsmessage.Message) []string {
+	var s []string
+	for _, r := range append(msg.Answers, msg.Additionals...) {
+		s = append(s, r.Header.Type.String()[4:]+" "+r.Header.Name.String())
+	}
+	sort.Strings(s)
+	return s
+}
+
+func TestMDNSResponder(t *testing.T) {
+	lo, err := net.InterfaceByName("lo")
+	if err != nil {
+		t.Skipf("no loopback interface: %v", err)
+	}
+	// a free port, to not interfere with a real mdns responder
+	free, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ListenPacket() error = %v", err)
+	}
+	group := &net.UDPAddr{IP: mdnsGroup.IP, Port: free.LocalAddr().(*net.UDPAddr).Port}
+	free.Close()
+
+	listener, err := net.ListenUDP("udp4", group)
+	if err != nil {
+		t.Fatalf("ListenUDP() error = %v", err)
+	}
+	defer listener.Close()
+	if err = ipv4.NewPacketConn(listener).JoinGroup(lo, group); err != nil {
+		t.Skipf("no multicast on loopback: %v", err)
+	}
+
+	mac, _ := net.ParseMAC("00:17:88:10:04:91")
+	r, err := NewMDNSResponder(MDNSConfig{Interface: lo, Group: group, IP: net.IPv4(127, 0, 0, 1), Port: 8080, MAC: mac})
+	if err != nil {
+		t.Fatalf("NewMDNSResponder() error = %v", err)
+	}
+
+	instance := "Philips Hue - 100491._hue._tcp.local."
+	host := "hue-001788100491.local."
+	all := []string{"A " + host, "PTR _hue._tcp.local.", "SRV " + instance, "TXT " + instance}
+	announced := readMDNS(t, listener, 300*time.Millisecond)
+	if len(announced) != 1 || len(summary(announced[0])) != 4 {
+		t.Fatalf("announcement want: %v; got %v", all, announced)
+	}
+	for i, s := range summary(announced[0]) {
+		if s != all[i] {
+			t.Errorf("announcement want: %v; got %v", all, summary(announced[0]))
+			break
+		}
+	}
+
+	client, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("ListenUDP() error = %v", err)
+	}
+	defer client.Close()
+	if err = ipv4.NewPacketConn(client).SetMulticastInterface(lo); err != nil {
+		t.Fatalf("SetMulticastInterface() error = %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		qtype dnsmessage.Type
+		want  []string
+	}{
+		{"_hue._tcp.local.", dnsmessage.TypePTR, all},
+		{"_services._dns-sd._udp.local.", dnsmessage.TypePTR, []string{"PTR _services._dns-sd._udp.local."}},
+		{instance, dnsmessage.TypeTXT, []string{"A " + host, "TXT " + instance}},
+		{host, dnsmessage.TypeA, []string{"A " + host}},
+		{"_wled._tcp.local.", dnsmessage.TypePTR, nil},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query := dnsmessage.Message{
+				Header:    dnsmessage.Header{ID: uint16(i + 1)},
+				Questions: []dnsmessage.Question{{Name: dnsmessage.MustNewName(tt.name), Type: tt.qtype, Class: dnsmessage.ClassINET}},
+			}
+			buf, _ := query.Pack()
+			if _, err := client.WriteTo(buf, group); err != nil {
+				t.Fatalf("WriteTo() error = %v", err)
+			}
+			resp := readMDNS(t, client, 300*time.Millisecond)
+			if tt.want == nil {
+				if len(resp) != 0 {
+					t.Errorf("query %s want no response; got %v", tt.name, resp)
+				}
+				return
+			}
+			if len(resp) != 1 {
+				t.Fatalf("query %s want 1 response; got %d", tt.name, len(resp))
+			}
+			if resp[0].ID != query.ID {
+				t.Errorf("response id want: %v; got %v", query.ID, resp[0].ID)
+			}
+			got := summary(resp[0])
+			if len(got) != len(tt.want) {
+				t.Fatalf("query %s want: %v; got %v", tt.name, tt.want, got)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("query %s want: %v; got %v", tt.name, tt.want, got)
+					break
+				}
+			}
+			for _, rr := range append(resp[0].Answers, resp[0].Additionals...) {
+				switch body := rr.Body.(type) {
+				case *dnsmessage.TXTResource:
+					if len(body.TXT) != 2 || body.TXT[0] != "bridgeid=001788fffe100491" || body.TXT[1] != "modelid=BSB002" {
+						t.Errorf("txt want: [bridgeid=001788fffe100491 modelid=BSB002]; got %v", body.TXT)
+					}
+				case *dnsmessage.SRVResource:
+					if body.Port != 8080 || body.Target.String() != host {
+						t.Errorf("srv want: %s:8080; got %s:%d", host, body.Target, body.Port)
+					}
+				case *dnsmessage.AResource:
+					if net.IP(body.A[:]).String() != "127.0.0.1" {
+						t.Errorf("a want: 127.0.0.1; got %v", net.IP(body.A[:]))
+					}
+				}
+			}
+		})
+	}
+	readMDNS(t, listener, 50*time.Millisecond)
+
+	if err = r.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+	bye := readMDNS(t, listener, 300*time.Millisecond)
+	if len(bye) != 1 || len(bye[0].Answers) != 1 || bye[0].Answers[0].Header.TTL != 0 {
+		t.Errorf("goodbye want: PTR with ttl 0; got %v", bye)
+	}
+}
@@ -0,0 +1,196 @@
+package home
+
+import (
+	"context"
+	"fmt"
+	"homeserver/config"
+	"net"
+	"time"
+)
+
+// NetworkRules select the interface and ip the bridge is advertised with. Without rules the first
+// interface with a private IPv4 address is used.
+type NetworkRules struct {
+	// Interface is the name of the interface to use, or empty for any.
+	Interface string
+	// Subnet only allows addresses within, or any if nil.
+	Subnet *net.IPNet
+	// IP overrides the detection. The mac address is taken from the interface having IP, if any.
+	IP net.IP
+}
+
+// ParseNetworkRules builds the rules from the settings "interface", "subnet" (cidr) and
+// "advertiseIP".
+func ParseNetworkRules() (NetworkRules, error) {
+	rules := NetworkRules{Interface: config.GetString("interface")}
+	if s := config.GetString("subnet"); s != "" {
+		_, subnet, err := net.ParseCIDR(s)
+		if err != nil {
+			return rules, fmt.Errorf("invalid subnet: %v", err)
+		}
+		rules.Subnet = subnet
+	}
+	if s := config.GetString("advertiseIP"); s != "" {
+		if rules.IP = net.ParseIP(s); rules.IP == nil {
+			return rules, fmt.Errorf("invalid ip '%s'", s)
+		}
+	}
+	return rules, nil
+}
+
+// Network is the interface and ip selected by NetworkRules.
+type Network struct {
+	Interface string
+	MAC       net.HardwareAddr
+	IP        net.IP
+}
+
+// netInterface is an interface with its addresses, as far as needed for the selection.
+type netInterface struct {
+	Name  string
+	Flags net.Flags
+	MAC   net.HardwareAddr
+	IPs   []net.IP
+}
+
+func listInterfaces() ([]netInterface, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	list := make([]netInterface, 0, len(ifaces))
+	for _, iface := range ifaces {
+		ni := netInterface{Name: iface.Name, Flags: iface.Flags, MAC: iface.HardwareAddr}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok {
+				ni.IPs = append(ni.IPs, ipNet.IP)
+			}
+		}
+		list = append(list, ni)
+	}
+	return list, nil
+}
+
+// DetectNetwork selects the interface and ip of the bridge from the local interfaces. It does not
+// need a route to the internet.
+func DetectNetwork(rules NetworkRules) (Network, error) {
+	ifaces, err := listInterfaces()
+	if err != nil {
+		return Network{}, err
+	}
+	return selectNetwork(ifaces, rules)
+}
+
+func selectNetwork(ifaces []netInterface, rules NetworkRules) (Network, error) {
+	usable := func(iface netInterface) bool {
+		return iface.Flags&net.FlagUp != 0 && iface.Flags&net.FlagLoopback == 0 &&
+			(rules.Interface == "" || iface.Name == rules.Interface)
+	}
+
+	if rules.IP != nil {
+		n := Network{IP: rules.IP}
+		for _, iface := range ifaces {
+			for _, ip := range iface.IPs {
+				if ip.Equal(rules.IP) {
+					return Network{iface.Name, iface.MAC, rules.IP}, nil
+				}
+			}
+			if n.MAC == nil && len(iface.MAC) > 0 && usable(iface) {
+				n.Interface, n.MAC = iface.Name, iface.MAC
+			}
+		}
+		return n, nil
+	}
+
+	var found *Network
+	for _, iface := range ifaces {
+		if !usable(iface) {
+			continue
+		}
+		for _, ip := range iface.IPs {
+			if ip.To4() == nil || (rules.Subnet != nil && !rules.Subnet.Contains(ip)) {
+				continue
+			}
+			if ip.IsPrivate() {
+				return Network{iface.Name, iface.MAC, ip.To4()}, nil
+			}
+			if found == nil && ip.IsGlobalUnicast() {
+				found = &Network{iface.Name, iface.MAC, ip.To4()}
+			}
+		}
+	}
+	if found == nil {
+		return Network{}, fmt.Errorf("no interface matches %s", rules)
+	}
+	return *found, nil
+}
+
+func (r NetworkRules) String() string {
+	s := "interface "
+	if r.Interface == "" {
+		s += "any"
+	} else {
+		s += r.Interface
+	}
+	if r.Subnet != nil {
+		s += ", subnet " + r.Subnet.String()
+	}
+	return s
+}
+
+// ApplyNetwork saves n as the settings "macAddr", "interfaceName" and "ip". "ip" is set last, so
+// its watchers see the new mac address.
+func ApplyNetwork(n Network) {
+	config.SetString("macAddr", n.MAC.String())
+	config.SetString("interfaceName", n.Interface)
+	config.SetString("ip", n.IP.String())
+}
+
+// UpdateNetwork detects the network by the rules of the current settings and applies it, if it
+// differs from the one in use.
+func UpdateNetwork() (n Network, changed bool, err error) {
+	rules, err := ParseNetworkRules()
+	if err != nil {
+		return n, false, err
+	}
+	if n, err = DetectNetwork(rules); err != nil {
+		return n, false, err
+	}
+	if n.IP.String() == config.GetString("ip") && n.MAC.String() == config.GetString("macAddr") {
+		return n, false, nil
+	}
+	ApplyNetwork(n)
+	return n, true, nil
+}
+
+// WatchNetwork detects the network every interval and applies it when it changed, e.g. after a
+// DHCP renewal. WatchNetwork blocks until ctx is done.
+func WatchNetwork(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	failing := false
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		n, changed, err := UpdateNetwork()
+		if err != nil {
+			// report only once until the network is back
+			if !failing {
+				log.Printf("ERROR: could not detect network: %+v", err)
+			}
+			failing = true
+			continue
+		}
+		failing = false
+		if changed {
+			log.Printf("Network changed to %s on %s", n.IP, n.Interface)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package home
+
+import (
+	"net"
+	"testing"
+)
+
+func TestSelectNetwork(t *testing.T) {
+	mac := func(s string) net.HardwareAddr {
+		m, _ := net.ParseMAC(s)
+		return m
+	}
+	up := net.FlagUp | net.FlagMulticast
+	ifaces := []netInterface{
+		{"lo", up | net.FlagLoopback, nil, []net.IP{net.ParseIP("127.0.0.1"), net.ParseIP("::1")}},
+		{"docker0", 0, mac("02:42:00:00:00:01"), []net.IP{net.ParseIP("172.17.0.1")}},
+		{"tun0", up, nil, []net.IP{net.ParseIP("8.8.4.1")}},
+		{"eth0", up, mac("00:11:22:33:44:55"), []net.IP{net.ParseIP("fe80::1"), net.ParseIP("192.168.1.20")}},
+		{"wlan0", up, mac("00:11:22:33:44:66"), []net.IP{net.ParseIP("10.0.0.5")}},
+	}
+	_, subnet, _ := net.ParseCIDR("10.0.0.0/8")
+	_, noSubnet, _ := net.ParseCIDR("192.168.2.0/24")
+
+	tests := []struct {
+		name    string
+		ifaces  []netInterface
+		rules   NetworkRules
+		want    string
+		wantMAC string
+		wantErr bool
+	}{
+		{"first private", ifaces, NetworkRules{}, "192.168.1.20", "00:11:22:33:44:55", false},
+		{"by name", ifaces, NetworkRules{Interface: "wlan0"}, "10.0.0.5", "00:11:22:33:44:66", false},
+		{"by subnet", ifaces, NetworkRules{Subnet: subnet}, "10.0.0.5", "00:11:22:33:44:66", false},
+		{"interface down", ifaces, NetworkRules{Interface: "docker0"}, "", "", true},
+		{"no match", ifaces, NetworkRules{Subnet: noSubnet}, "", "", true},
+		{"public only", ifaces[:3], NetworkRules{}, "8.8.4.1", "", false},
+		{"no interfaces", nil, NetworkRules{}, "", "", true},
+		{"override", ifaces, NetworkRules{IP: net.ParseIP("10.0.0.5")}, "10.0.0.5", "00:11:22:33:44:66", false},
+		{"override not local", ifaces, NetworkRules{IP: net.ParseIP("203.0.113.7")}, "203.0.113.7", "00:11:22:33:44:55", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n, err := selectNetwork(tt.ifaces, tt.rules)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("selectNetwork() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if n.IP.String() != tt.want {
+				t.Errorf("selectNetwork() ip want: %v; got %v", tt.want, n.IP)
+			}
+			if n.MAC.String() != tt.wantMAC {
+				t.Errorf("selectNetwork() mac want: %v; got %v", tt.wantMAC, n.MAC)
+			}
+		})
+	}
+}
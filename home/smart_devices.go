@@ -1,38 +1,140 @@
 package home
 
 import (
+	"errors"
 	"fmt"
 	"homeserver/config"
 	logger "log"
-
-	"github.com/koron/go-ssdp"
+	"net"
+	"sync"
+	"time"
 )
 
-var log *logger.Logger = logger.New(logger.Writer(), "[Smart Device] ", logger.LstdFlags|logger.Lmsgprefix)
-var ad *ssdp.Advertiser
+var log *logger.Logger = logger.New(config.LogOutput, "[Smart Device] ", logger.LstdFlags|logger.Lmsgprefix)
+
+var (
+	adMu      sync.Mutex
+	ad        *SSDPResponder
+	mdns      *MDNSResponder
+	stopWatch func()
+)
 
+// AdvertiseSmartDevices announces the bridge by ssdp and mdns, as enabled by the settings
+// "features.ssdp" and "features.mdns". The announcements follow changes of the settings "ip",
+// "port", "ssdpInterval" and "ssdpMaxAge" until CloseSmartDeviceAdvertiser is called.
 func AdvertiseSmartDevices() {
-	st := "urn:schemas-upnp-org:device:basic:1"
-	usn := fmt.Sprintf("uuid:2f402f80-da50-11e1-9b23-%s::upnp:rootdevice", config.GetString("macAddr"))
-	location := fmt.Sprintf("http://%s:%d/%s", config.GetString("ip"), config.GetInt("port"), "description.xml")
-	server := "FreeRTOS/6.0.5, UPnP/1.0, IpBridge/1.17.0"
+	adMu.Lock()
+	defer adMu.Unlock()
+	if stopWatch != nil {
+		return
+	}
+	advertise()
 
-	var err error
-	ad, err = ssdp.Advertise(st, usn, location, server, 1800)
-	if err != nil {
-		log.Printf("Could not avertise device: %+v", err)
+	changed := make(chan string)
+	var wg sync.WaitGroup
+	stops := []func(){
+		forwardChanges[string]("ip", changed, &wg),
+		forwardChanges[int]("port", changed, &wg),
+		forwardChanges[int]("ssdpInterval", changed, &wg),
+		forwardChanges[int]("ssdpMaxAge", changed, &wg),
+	}
+	stopWatch = func() {
+		for _, stop := range stops {
+			stop()
+		}
+	}
+	go func() {
+		wg.Wait()
+		close(changed)
+	}()
+	go func() {
+		for k := range changed {
+			adMu.Lock()
+			if stopWatch == nil {
+				// closed meanwhile
+				adMu.Unlock()
+				continue
+			}
+			log.Printf("Setting %s changed, advertising %s:%d again", k, config.GetString("ip"), config.GetInt("port"))
+			if err := bye(); err != nil {
+				log.Printf("ERROR: could not stop previous advertisement: %+v", err)
+			}
+			advertise()
+			adMu.Unlock()
+		}
+	}()
+}
+
+// forwardChanges sends k to changed whenever the setting k of type T changes, until the returned
+// function is called.
+func forwardChanges[T any](k string, changed chan<- string, wg *sync.WaitGroup) func() {
+	values, stop := config.Watch[T](k)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for range values {
+			changed <- k
+		}
+	}()
+	return stop
+}
+
+// advertise starts the enabled responders. adMu must be held.
+func advertise() {
+	if config.GetString("ip") == "" {
+		log.Print("No ip detected yet, advertising once there is one")
 		return
 	}
+	ip := net.ParseIP(config.GetString("ip"))
+	mac, _ := net.ParseMAC(config.GetString("macAddr"))
+	var iface *net.Interface
+	if name := config.GetString("interfaceName"); name != "" {
+		iface, _ = net.InterfaceByName(name)
+	}
+
+	var err error
+	if config.GetBool("features.ssdp") {
+		ad, err = NewSSDPResponder(SSDPConfig{
+			Interface: iface,
+			Location:  fmt.Sprintf("http://%s:%d/%s", ip, config.GetInt("port"), "description.xml"),
+			MAC:       mac,
+			MaxAge:    config.GetInt("ssdpMaxAge"),
+			Interval:  time.Duration(config.GetInt("ssdpInterval")) * time.Second,
+		})
+		if err != nil {
+			log.Printf("Could not avertise device: %+v", err)
+			ad = nil
+		}
+	}
+	if config.GetBool("features.mdns") {
+		mdns, err = NewMDNSResponder(MDNSConfig{Interface: iface, IP: ip, Port: config.GetInt("port"), MAC: mac})
+		if err != nil {
+			log.Printf("ERROR: could not advertise by mdns: %+v", err)
+			mdns = nil
+		}
+	}
+}
 
-	return
+// bye ends the current advertisements. adMu must be held.
+func bye() error {
+	var errs []error
+	if ad != nil {
+		errs = append(errs, ad.Close())
+		ad = nil
+	}
+	if mdns != nil {
+		errs = append(errs, mdns.Close())
+		mdns = nil
+	}
+	return errors.Join(errs...)
 }
 
 func CloseSmartDeviceAdvertiser() error {
-	if ad == nil {
-		return nil
-	}
-	if err := ad.Bye(); err != nil {
-		return err
+	adMu.Lock()
+	defer adMu.Unlock()
+	if stopWatch != nil {
+		stopWatch()
+		stopWatch = nil
 	}
-	return ad.Close()
+	return bye()
 }
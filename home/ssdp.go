@@ -0,0 +1,283 @@
+package home
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/ipv4"
+)
+
+var ssdpGroup = &net.UDPAddr{IP: net.IPv4(239, 255, 255, 250), Port: 1900}
+
+const (
+	ssdpServer     = "Linux/3.14.0 UPnP/1.0 IpBridge/1.17.0"
+	ssdpRootDevice = "upnp:rootdevice"
+	ssdpBasic      = "urn:schemas-upnp-org:device:basic:1"
+	ssdpAll        = "ssdp:all"
+	// ssdpMaxDelay caps the random delay of search responses requested by MX.
+	ssdpMaxDelay = 5 * time.Second
+)
+
+// BridgeID returns the id of the bridge derived from its mac address like a Hue bridge does, e.g.
+// "001788FFFE100491" for 00:17:88:10:04:91.
+func BridgeID(mac net.HardwareAddr) string {
+	mac = bridgeMAC(mac)
+	return strings.ToUpper(fmt.Sprintf("%x%s%x", []byte(mac[:3]), "fffe", []byte(mac[3:])))
+}
+
+// BridgeUUID returns the upnp uuid of the bridge, e.g. "2f402f80-da50-11e1-9b23-001788100491".
+func BridgeUUID(mac net.HardwareAddr) string {
+	return "2f402f80-da50-11e1-9b23-" + BridgeSerial(mac)
+}
+
+// BridgeSerial returns the serial number of the bridge, which is its mac address without colons.
+func BridgeSerial(mac net.HardwareAddr) string {
+	return fmt.Sprintf("%x", []byte(bridgeMAC(mac)))
+}
+
+// bridgeMAC returns mac, or zeros if mac is not a 48 bit address, e.g. as there is no network yet.
+func bridgeMAC(mac net.HardwareAddr) net.HardwareAddr {
+	if len(mac) != 6 {
+		return make(net.HardwareAddr, 6)
+	}
+	return mac
+}
+
+// SSDPConfig configures an SSDPResponder.
+type SSDPConfig struct {
+	// Interface sends and receives the messages, or all multicast interfaces if nil.
+	Interface *net.Interface
+	// Group is the multicast address, 239.255.255.250:1900 if nil.
+	Group *net.UDPAddr
+	// Location is the url of the description.xml of the bridge.
+	Location string
+	// MAC identifies the bridge.
+	MAC net.HardwareAddr
+	// MaxAge is how many seconds an announcement is valid, 100 if not set.
+	MaxAge int
+	// Interval is the time between alive notifications, half of MaxAge if not set.
+	Interval time.Duration
+}
+
+// ssdpTarget is a notification type of the bridge with its unique service name.
+type ssdpTarget struct {
+	nt, usn string
+}
+
+// SSDPResponder announces the bridge by ssdp like a Hue bridge. It sends alive notifications
+// periodically and answers searches for upnp:rootdevice, the uuid of the bridge, the basic device
+// type and ssdp:all. All messages include the hue-bridgeid header.
+type SSDPResponder struct {
+	cfg     SSDPConfig
+	targets []ssdpTarget
+	conn    *ipv4.PacketConn
+	done    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// NewSSDPResponder joins the multicast group and starts responding. It sends the first alive
+// notifications right away.
+func NewSSDPResponder(cfg SSDPConfig) (*SSDPResponder, error) {
+	if cfg.Group == nil {
+		cfg.Group = ssdpGroup
+	}
+	if cfg.MaxAge <= 0 {
+		cfg.MaxAge = 100
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = time.Duration(cfg.MaxAge) * time.Second / 2
+	}
+
+	c, err := net.ListenUDP("udp4", cfg.Group)
+	if err != nil {
+		return nil, err
+	}
+	conn := ipv4.NewPacketConn(c)
+	if err = joinGroup(conn, cfg.Interface, cfg.Group); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetMulticastLoopback(true)
+	if cfg.Interface != nil {
+		if err = conn.SetMulticastInterface(cfg.Interface); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	// a Hue bridge uses the plain uuid as usn of the basic device type
+	uuid := "uuid:" + BridgeUUID(cfg.MAC)
+	r := &SSDPResponder{
+		cfg: cfg,
+		targets: []ssdpTarget{
+			{ssdpRootDevice, uuid + "::" + ssdpRootDevice},
+			{uuid, uuid},
+			{ssdpBasic, uuid},
+		},
+		conn: conn,
+		done: make(chan struct{}),
+	}
+	r.wg.Add(2)
+	go r.receive()
+	go r.notify()
+	return r, nil
+}
+
+// joinGroup joins group on iface, or on all multicast interfaces if iface is nil.
+func joinGroup(conn *ipv4.PacketConn, iface *net.Interface, group *net.UDPAddr) error {
+	if iface != nil {
+		return conn.JoinGroup(iface, group)
+	}
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return err
+	}
+	joined := 0
+	for i := range ifaces {
+		if ifaces[i].Flags&net.FlagUp == 0 || ifaces[i].Flags&net.FlagMulticast == 0 {
+			continue
+		}
+		if err := conn.JoinGroup(&ifaces[i], group); err == nil {
+			joined++
+		}
+	}
+	if joined == 0 {
+		return errors.New("could not join the ssdp group on any interface")
+	}
+	return nil
+}
+
+// Close sends byebye notifications and stops responding.
+func (r *SSDPResponder) Close() error {
+	var errs []error
+	for _, t := range r.targets {
+		if err := r.send(r.message("NOTIFY * HTTP/1.1", "NT", t, "ssdp:byebye"), r.cfg.Group); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	close(r.done)
+	errs = append(errs, r.conn.Close())
+	r.wg.Wait()
+	return errors.Join(errs...)
+}
+
+// Alive sends an alive notification for every notification type.
+func (r *SSDPResponder) Alive() error {
+	for _, t := range r.targets {
+		if err := r.send(r.message("NOTIFY * HTTP/1.1", "NT", t, "ssdp:alive"), r.cfg.Group); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *SSDPResponder) notify() {
+	defer r.wg.Done()
+	ticker := time.NewTicker(r.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		if err := r.Alive(); err != nil {
+			log.Printf("ERROR: could not send ssdp alive: %+v", err)
+		}
+		select {
+		case <-r.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *SSDPResponder) receive() {
+	defer r.wg.Done()
+	buf := make([]byte, 65535)
+	for {
+		n, _, from, err := r.conn.ReadFrom(buf)
+		if err != nil {
+			select {
+			case <-r.done:
+			default:
+				log.Printf("ERROR: could not receive ssdp messages: %+v", err)
+			}
+			return
+		}
+		if bytes.HasPrefix(buf[:n], []byte("M-SEARCH ")) {
+			r.search(from, buf[:n])
+		}
+	}
+}
+
+// search answers an M-SEARCH request after the random delay requested by its MX header.
+func (r *SSDPResponder) search(from net.Addr, raw []byte) {
+	req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(raw)))
+	if err != nil || req.Header.Get("MAN") != `"ssdp:discover"` {
+		return
+	}
+	st := req.Header.Get("ST")
+	var matched []ssdpTarget
+	for _, t := range r.targets {
+		if st == ssdpAll || st == t.nt {
+			matched = append(matched, t)
+		}
+	}
+	if len(matched) == 0 {
+		return
+	}
+
+	var delay time.Duration
+	if mx, err := strconv.Atoi(req.Header.Get("MX")); err == nil && mx > 0 {
+		delay = time.Duration(rand.Int63n(int64(min(time.Duration(mx)*time.Second, ssdpMaxDelay))))
+	}
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		select {
+		case <-r.done:
+			return
+		case <-time.After(delay):
+		}
+		for _, t := range matched {
+			if err := r.send(r.message("HTTP/1.1 200 OK", "ST", t, ""), from); err != nil {
+				log.Printf("ERROR: could not answer M-SEARCH from %s: %+v", from, err)
+				return
+			}
+		}
+	}()
+}
+
+// message builds a notification or search response for t. The type of t is sent in the header
+// typeHeader, "NT" or "ST". nts is empty for search responses.
+func (r *SSDPResponder) message(start, typeHeader string, t ssdpTarget, nts string) []byte {
+	b := new(bytes.Buffer)
+	fmt.Fprintf(b, "%s\r\n", start)
+	fmt.Fprintf(b, "HOST: %s\r\n", r.cfg.Group)
+	if nts == "" {
+		b.WriteString("EXT:\r\n")
+	}
+	if nts != "ssdp:byebye" {
+		fmt.Fprintf(b, "CACHE-CONTROL: max-age=%d\r\n", r.cfg.MaxAge)
+		fmt.Fprintf(b, "LOCATION: %s\r\n", r.cfg.Location)
+		fmt.Fprintf(b, "SERVER: %s\r\n", ssdpServer)
+	}
+	if nts != "" {
+		fmt.Fprintf(b, "NTS: %s\r\n", nts)
+	}
+	fmt.Fprintf(b, "hue-bridgeid: %s\r\n", BridgeID(r.cfg.MAC))
+	fmt.Fprintf(b, "%s: %s\r\n", typeHeader, t.nt)
+	fmt.Fprintf(b, "USN: %s\r\n", t.usn)
+	b.WriteString("\r\n")
+	return b.Bytes()
+}
+
+func (r *SSDPResponder) send(msg []byte, to net.Addr) error {
+	_, err := r.conn.WriteTo(msg, nil, to)
+	return err
+}
@@ -0,0 +1,167 @@
+package home
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/ipv4"
+)
+
+func TestBridgeID(t *testing.T) {
+	mac, _ := net.ParseMAC("00:17:88:10:04:91")
+	if id := BridgeID(mac); id != "001788FFFE100491" {
+		t.Errorf("BridgeID() want: %v; got %v", "001788FFFE100491", id)
+	}
+	if uuid := BridgeUUID(mac); uuid != "2f402f80-da50-11e1-9b23-001788100491" {
+		t.Errorf("BridgeUUID() want: %v; got %v", "2f402f80-da50-11e1-9b23-001788100491", uuid)
+	}
+	if id := BridgeID(nil); id != "000000FFFE000000" {
+		t.Errorf("BridgeID() without mac want: %v; got %v", "000000FFFE000000", id)
+	}
+}
+
+// ssdpMessage is a received ssdp message with its headers.
+type ssdpMessage struct {
+	start  string
+	header http.Header
+}
+
+func readSSDP(t *testing.T, conn net.PacketConn, timeout time.Duration) []ssdpMessage {
+	t.Helper()
+	var msgs []ssdpMessage
+	buf := make([]byte, 65535)
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return msgs
+		}
+		r := bufio.NewReader(bytes.NewReader(buf[:n]))
+		start, _ := r.ReadString('\n')
+		// parse the headers like a request, as responses start with a status line
+		req, err := http.ReadRequest(bufio.NewReader(strings.NewReader("GET / HTTP/1.1\r\n" + string(buf[len(start):n]))))
+		if err != nil {
+			t.Fatalf("invalid ssdp message %q: %v", buf[:n], err)
+		}
+		msgs = append(msgs, ssdpMessage{strings.TrimSpace(start), req.Header})
+	}
+}
+
+func headers(msgs []ssdpMessage, key string) []string {
+	var values []string
+	for _, m := range msgs {
+		values = append(values, m.header.Get(key))
+	}
+	sort.Strings(values)
+	return values
+}
+
+func TestSSDPResponder(t *testing.T) {
+	lo, err := net.InterfaceByName("lo")
+	if err != nil {
+		t.Skipf("no loopback interface: %v", err)
+	}
+	// a free port, to not interfere with a real ssdp group
+	free, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ListenPacket() error = %v", err)
+	}
+	group := &net.UDPAddr{IP: ssdpGroup.IP, Port: free.LocalAddr().(*net.UDPAddr).Port}
+	free.Close()
+
+	listener, err := net.ListenUDP("udp4", group)
+	if err != nil {
+		t.Fatalf("ListenUDP() error = %v", err)
+	}
+	defer listener.Close()
+	if err = ipv4.NewPacketConn(listener).JoinGroup(lo, group); err != nil {
+		t.Skipf("no multicast on loopback: %v", err)
+	}
+
+	mac, _ := net.ParseMAC("00:17:88:10:04:91")
+	r, err := NewSSDPResponder(SSDPConfig{
+		Interface: lo,
+		Group:     group,
+		Location:  "http://127.0.0.1:80/description.xml",
+		MAC:       mac,
+		Interval:  time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("NewSSDPResponder() error = %v", err)
+	}
+	uuid := "uuid:2f402f80-da50-11e1-9b23-001788100491"
+	// sorted like headers returns them
+	nts := []string{"upnp:rootdevice", "urn:schemas-upnp-org:device:basic:1", uuid}
+
+	alive := readSSDP(t, listener, 300*time.Millisecond)
+	if got := headers(alive, "NT"); strings.Join(got, " ") != strings.Join(nts, " ") {
+		t.Errorf("alive NT want: %v; got %v", nts, got)
+	}
+	for _, m := range alive {
+		if m.start != "NOTIFY * HTTP/1.1" || m.header.Get("NTS") != "ssdp:alive" ||
+			m.header.Get("hue-bridgeid") != "001788FFFE100491" || m.header.Get("CACHE-CONTROL") != "max-age=100" ||
+			m.header.Get("LOCATION") != "http://127.0.0.1:80/description.xml" {
+			t.Errorf("invalid alive notification: %v %v", m.start, m.header)
+		}
+	}
+
+	client, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("ListenUDP() error = %v", err)
+	}
+	defer client.Close()
+	if err = ipv4.NewPacketConn(client).SetMulticastInterface(lo); err != nil {
+		t.Fatalf("SetMulticastInterface() error = %v", err)
+	}
+
+	tests := []struct {
+		st   string
+		want []string
+	}{
+		{"ssdp:all", nts},
+		{"upnp:rootdevice", []string{"upnp:rootdevice"}},
+		{uuid, []string{uuid}},
+		{"urn:schemas-upnp-org:device:basic:1", []string{"urn:schemas-upnp-org:device:basic:1"}},
+		{"urn:schemas-upnp-org:device:MediaRenderer:1", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.st, func(t *testing.T) {
+			search := "M-SEARCH * HTTP/1.1\r\nHOST: " + group.String() + "\r\nMAN: \"ssdp:discover\"\r\nST: " + tt.st + "\r\n\r\n"
+			if _, err := client.WriteTo([]byte(search), group); err != nil {
+				t.Fatalf("WriteTo() error = %v", err)
+			}
+			resp := readSSDP(t, client, 300*time.Millisecond)
+			if got := headers(resp, "ST"); strings.Join(got, " ") != strings.Join(tt.want, " ") {
+				t.Errorf("M-SEARCH %s ST want: %v; got %v", tt.st, tt.want, got)
+			}
+			for _, m := range resp {
+				if m.start != "HTTP/1.1 200 OK" || m.header.Get("hue-bridgeid") != "001788FFFE100491" ||
+					!strings.HasPrefix(m.header.Get("USN"), uuid) {
+					t.Errorf("invalid search response: %v %v", m.start, m.header)
+				}
+			}
+		})
+	}
+	// the listener saw the searches as well
+	readSSDP(t, listener, 50*time.Millisecond)
+
+	if err = r.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+	bye := readSSDP(t, listener, 300*time.Millisecond)
+	var byeNTs []ssdpMessage
+	for _, m := range bye {
+		if m.header.Get("NTS") == "ssdp:byebye" {
+			byeNTs = append(byeNTs, m)
+		}
+	}
+	if got := headers(byeNTs, "NT"); strings.Join(got, " ") != strings.Join(nts, " ") {
+		t.Errorf("byebye NT want: %v; got %v", nts, got)
+	}
+}
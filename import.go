@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"homeserver/home/driver"
+	"homeserver/webserver/api"
+	"os"
+	"time"
+)
+
+// importLights implements the "import" command, which adds the lights of another system to the
+// lights file:
+//
+//	homeserver import hue <address> <username>
+//	homeserver import homeassistant <address> <token>
+func importLights(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: %s import <hue|homeassistant> ...", os.Args[0])
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var (
+		devices []driver.Device
+		err     error
+	)
+	switch args[0] {
+	case "hue":
+		if len(args) != 3 {
+			return fmt.Errorf("usage: %s import hue <address> <username>", os.Args[0])
+		}
+		devices, err = driver.HueLights(ctx, args[1], args[2])
+	case "homeassistant":
+		if len(args) != 3 {
+			return fmt.Errorf("usage: %s import homeassistant <address> <token>", os.Args[0])
+		}
+		devices, err = driver.HomeAssistantLights(ctx, args[1], args[2])
+	default:
+		return fmt.Errorf("unknown import source '%s'", args[0])
+	}
+	if err != nil {
+		return err
+	}
+
+	added, err := api.ImportLights(devices)
+	for id, name := range added {
+		log.Printf("Imported light '%s' as %s", name, id)
+	}
+	log.Printf("Imported %d of %d lights", len(added), len(devices))
+	return err
+}
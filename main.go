@@ -2,44 +2,153 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"homeserver/config"
 	"homeserver/home"
+	"homeserver/home/driver"
 	"homeserver/webserver"
+	"homeserver/webserver/api"
 	logger "log"
-	"net"
+	"os"
 	"os/signal"
 	"syscall"
+	"time"
 )
 
-var log *logger.Logger = logger.New(logger.Writer(), "[MAIN] ", logger.LstdFlags|logger.Lmsgprefix)
+// shutdownTimeout is how long running requests may take on shutdown.
+const shutdownTimeout = 10 * time.Second
 
-func init() {
-	config.SetInt("port", 80)
+var log *logger.Logger = logger.New(config.LogOutput, "[MAIN] ", logger.LstdFlags|logger.Lmsgprefix)
 
-	iface, err := net.InterfaceByName("en0")
+func main() {
+	settings, args, err := config.LoadSettings(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	} else if err != nil {
+		log.Fatalf("Invalid settings: %+v", err)
+	}
+	settings.Apply()
+	if err = config.SetLogFile(settings.LogFile); err != nil {
+		log.Fatalf("Could not open log file: %+v", err)
+	}
+
+	if len(args) > 0 && args[0] == "migrate" {
+		if err := migrateStore(args[1:]); err != nil {
+			log.Fatalf("Migration failed: %+v", err)
+		}
+		return
+	}
+
+	store, err := openStore(settings.StoreSpec())
 	if err != nil {
-		log.Panicf("could not get interface 'en0': %+v", err)
+		log.Fatalf("Could not open store: %+v", err)
 	}
-	config.SetString("macAddr", iface.HardwareAddr.String())
-}
+	defer store.Close()
 
-func main() {
-	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
+	if len(args) > 0 && args[0] == "import" {
+		if err := importLights(args[1:]); err != nil {
+			log.Fatalf("Import failed: %+v", err)
+		}
+		return
+	}
+
+	if err = api.CheckLights(settings.Validation == "strict"); err != nil {
+		log.Fatalf("Invalid lights: %+v", err)
+	}
+
+	if network, _, err := home.UpdateNetwork(); err != nil {
+		// not fatal, the network may come up later
+		log.Printf("ERROR: could not detect network: %+v", err)
+	} else {
+		log.Printf("Using %s on %s", network.IP, network.Interface)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
 	defer stop()
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
 
 	// go udp.Mcast()
 
-	// webserver
-	go func() {
-		log.Print("Starting webserver...")
-		err := webserver.Run()
+	server, err := webserver.Start()
+	if err != nil {
+		log.Fatalf("Could not start webserver: %+v", err)
+	}
+	log.Printf("Started the webserver on %s", server.Addr())
+
+	if settings.NetworkInterval > 0 {
+		go home.WatchNetwork(ctx, time.Duration(settings.NetworkInterval)*time.Second)
+	}
+	if settings.Features.SSDP || settings.Features.MDNS {
+		home.AdvertiseSmartDevices()
+	}
+
+	// external drivers
+	if settings.Features.Plugins {
+		err = driver.StartPlugins(ctx, func(devices []driver.Device) {
+			if _, err := api.ImportLights(devices); err != nil {
+				log.Printf("ERROR: could not add plugin devices: %+v", err)
+			}
+		})
+		if err != nil {
+			log.Printf("ERROR: could not start plugins: %+v", err)
+		}
+	}
+
+	// read back changes made directly on the devices
+	if settings.Features.Sync {
+		go api.SyncLightStates(ctx, 5*time.Second)
+	}
+	if settings.Features.Retries {
+		go api.RunRetries(ctx)
+	}
+	if js, ok := store.(config.JSONStore); ok && settings.Features.Watch {
+		err = js.Watch(ctx, []string{api.USERS, api.LIGHTS, api.RETRIES}, api.ValidateCollection, api.Reload)
 		if err != nil {
-			log.Fatalf("Could not start webserver: %+v", err)
+			log.Printf("ERROR: could not watch %s: %+v", js.Dir, err)
 		}
-		log.Print("Stated the webserver!")
-	}()
+	}
+	if settings.Features.Health {
+		go api.MonitorHealth(ctx, time.Duration(settings.HealthInterval)*time.Second)
+	}
 
-	home.AdvertiseSmartDevices()
+	// the reloader keeps its own copy of the settings
+	current := settings
+	api.SetReloader(func() api.ReloadResult { return reload(&current, store) })
 
-	<-ctx.Done()
+run:
+	for {
+		select {
+		case <-ctx.Done():
+			break run
+		case err = <-server.Err():
+			log.Printf("ERROR: webserver stopped: %+v", err)
+			break run
+		case <-hup:
+			log.Print("Reloading on SIGHUP...")
+			api.RunReload()
+		}
+	}
+	stop()
+	shutdown(server, settings)
+}
+
+// shutdown stops the webserver, waiting at most shutdownTimeout for running requests, ends the ssdp
+// and mdns advertisements and stores the states not sent to the devices yet.
+func shutdown(server *webserver.Server, settings config.Settings) {
+	log.Print("Shutting down...")
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("ERROR: could not stop the webserver gracefully: %+v", err)
+	}
+	if settings.Features.SSDP || settings.Features.MDNS {
+		if err := home.CloseSmartDeviceAdvertiser(); err != nil {
+			log.Printf("ERROR: could not end the advertisement: %+v", err)
+		}
+	}
+	api.FlushQueues()
+	log.Print("Stopped")
 }
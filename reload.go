@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"homeserver/config"
+	"homeserver/home"
+	"homeserver/webserver/api"
+	"os"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+// reload reads the settings again and applies those that can change while running. It also
+// reopens the log file, detects the network again, which restarts the ssdp advertisement if the ip
+// changed, and takes over changed data files. The webserver keeps listening.
+func reload(current *config.Settings, store config.Store) api.ReloadResult {
+	result := api.ReloadResult{Time: time.Now(), Changed: []string{}, Restart: []string{}, Errors: []string{}}
+	fail := func(err error) {
+		result.Errors = append(result.Errors, err.Error())
+	}
+
+	settings, _, err := config.LoadSettings(os.Args[1:])
+	if err != nil {
+		fail(fmt.Errorf("invalid settings, keeping the current ones: %v", err))
+		settings = *current
+	}
+	changed := current.Diff(settings)
+
+	// these were used to set up the server and the background tasks
+	settings.Listen = current.Listen
+	settings.Port = current.Port
+	settings.NetworkInterval = current.NetworkInterval
+	settings.DataDir = current.DataDir
+	settings.Store = current.Store
+	settings.HealthInterval = current.HealthInterval
+	settings.Features = current.Features
+	result.Changed = append(result.Changed, current.Diff(settings)...)
+	for _, name := range changed {
+		if !slices.Contains(result.Changed, name) {
+			result.Restart = append(result.Restart, name)
+		}
+	}
+	*current = settings
+	settings.Apply()
+
+	if err = config.SetLogFile(settings.LogFile); err != nil {
+		fail(fmt.Errorf("could not open log file: %v", err))
+	}
+	if network, changed, err := home.UpdateNetwork(); err != nil {
+		fail(fmt.Errorf("could not detect network: %v", err))
+	} else if changed {
+		log.Printf("Network changed to %s on %s", network.IP, network.Interface)
+	}
+	if js, ok := store.(config.JSONStore); ok && settings.Features.Watch {
+		err = js.Refresh([]string{api.USERS, api.LIGHTS, api.RETRIES}, api.ValidateCollection, api.Reload)
+		if err != nil {
+			fail(err)
+		}
+	}
+	if err = api.ReloadData(settings.Validation == "strict"); err != nil {
+		fail(err)
+	}
+
+	result.Success = len(result.Errors) == 0
+	return result
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"homeserver/config"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/exp/slices"
+)
+
+func TestReload(t *testing.T) {
+	store := config.NewMemoryStore()
+	defer config.SetStore(config.SetStore(store))
+	defer func(args []string) { os.Args = args }(os.Args)
+	os.Args = []string{"homeserver"}
+
+	file := filepath.Join(t.TempDir(), "homeserver.toml")
+	t.Setenv("HOMESERVER_CONFIG", file)
+	os.WriteFile(file, nil, 0644)
+	current, _, err := config.LoadSettings(nil)
+	if err != nil {
+		t.Fatalf("LoadSettings() error = %v", err)
+	}
+	current.Apply()
+	defer config.DefaultSettings().Apply()
+
+	// bridge_name is used right away, port only after a restart
+	os.WriteFile(file, []byte("bridge_name = \"Reloaded\"\nport = 8090\n"), 0644)
+	result := reload(&current, store)
+	if !slices.Equal(result.Changed, []string{"bridge_name"}) {
+		t.Errorf("reload() changed want: [bridge_name]; got %v", result.Changed)
+	}
+	if !slices.Equal(result.Restart, []string{"port"}) {
+		t.Errorf("reload() restart want: [port]; got %v", result.Restart)
+	}
+	if current.BridgeName != "Reloaded" || current.Port != config.DefaultSettings().Port {
+		t.Errorf("settings want: bridge name Reloaded on port %d; got %s on port %d",
+			config.DefaultSettings().Port, current.BridgeName, current.Port)
+	}
+	if name := config.GetString("bridgeName"); name != "Reloaded" {
+		t.Errorf("bridgeName in use want: Reloaded; got %s", name)
+	}
+	if port := config.GetInt("port"); port != config.DefaultSettings().Port {
+		t.Errorf("port in use want: %d; got %d", config.DefaultSettings().Port, port)
+	}
+
+	// invalid settings keep the current ones
+	os.WriteFile(file, []byte("port = \"eighty\"\n"), 0644)
+	result = reload(&current, store)
+	if result.Success || len(result.Errors) == 0 || len(result.Changed) != 0 || current.BridgeName != "Reloaded" {
+		t.Errorf("reload() of invalid settings want: error keeping the settings; got %+v, %+v", result, current)
+	}
+}
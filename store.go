@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"homeserver/config"
+	"homeserver/webserver/api"
+	"os"
+)
+
+// openStore opens the store described by spec (see config.OpenStore) and makes it the store of the
+// config package. Corrupt json files are restored from their backups and old data is
+// migrated to the current version.
+func openStore(spec string) (config.Store, error) {
+	s, err := config.OpenStore(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	if js, ok := s.(config.JSONStore); ok {
+		for _, c := range []string{api.USERS, api.LIGHTS, api.RETRIES} {
+			restored, err := js.Recover(c)
+			if err != nil {
+				s.Close()
+				return nil, fmt.Errorf("could not read %s: %v", js.File(c), err)
+			}
+			if restored != "" {
+				log.Printf("Restored %s from %s, as it was corrupt", js.File(c), restored)
+			}
+		}
+	}
+	if err = config.RunMigrations(s); err != nil {
+		s.Close()
+		return nil, err
+	}
+	config.SetStore(s)
+	return s, nil
+}
+
+// migrateStore implements the "migrate" command, which copies all data from one store to another:
+//
+//	homeserver migrate <from> <to>
+//
+// e.g. "homeserver migrate json:config bolt:config/homeserver.db".
+func migrateStore(args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: %s migrate <from> <to>", os.Args[0])
+	}
+	src, err := config.OpenStore(args[0])
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := config.OpenStore(args[1])
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	if err = config.Migrate(dst, src); err != nil {
+		return err
+	}
+	log.Printf("Copied all data from %s to %s", args[0], args[1])
+	return nil
+}
@@ -8,7 +8,7 @@ import (
 	"net/http"
 )
 
-var log *logger.Logger = logger.New(logger.Writer(), "[API] ", logger.LstdFlags|logger.Lmsgprefix)
+var log *logger.Logger = logger.New(config.LogOutput, "[API] ", logger.LstdFlags|logger.Lmsgprefix)
 
 type successResponse struct {
 	R any `json:"success"`
@@ -63,7 +63,7 @@ func GetUserInfo(w http.ResponseWriter, r *http.Request, user string) {
 func GetLights(w http.ResponseWriter, r *http.Request, user string) {
 	//verify user
 	u := &userInfo{}
-	if err := config.JSONLoad(USERFILE, user, u); err != nil {
+	if err := config.Load(USERS, user, u); err != nil {
 		log.Printf("Error: could not get user: %+v", err)
 		respondError(w, http.StatusBadRequest, errorResponse{apiError{
 			Type:        7,
@@ -80,6 +80,9 @@ func GetLights(w http.ResponseWriter, r *http.Request, user string) {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	for id, l := range lights {
+		lights[id] = l.public()
+	}
 
 	buf, err := json.Marshal(lights)
 	if err != nil {
@@ -93,7 +96,7 @@ func GetLights(w http.ResponseWriter, r *http.Request, user string) {
 func GetLightInfo(w http.ResponseWriter, r *http.Request, user, light string) {
 	// verify user
 	u := &userInfo{}
-	if err := config.JSONLoad(USERFILE, user, u); err != nil {
+	if err := config.Load(USERS, user, u); err != nil {
 		log.Printf("Error: could not get user: %+v", err)
 		respondError(w, http.StatusBadRequest, errorResponse{apiError{
 			Type:        7,
@@ -114,7 +117,7 @@ func GetLightInfo(w http.ResponseWriter, r *http.Request, user, light string) {
 		}})
 		return
 	}
-	buf, err := json.Marshal(l)
+	buf, err := json.Marshal(l.public())
 	if err != nil {
 		log.Printf("Error: could not marshal light response: %+v", err)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -146,7 +149,7 @@ func PutLightState(w http.ResponseWriter, r *http.Request, user, light string) {
 
 	// verify user
 	u := &userInfo{}
-	if err := config.JSONLoad(USERFILE, user, u); err != nil {
+	if err := config.Load(USERS, user, u); err != nil {
 		log.Printf("Error: could not get user: %+v", err)
 		respondError(w, http.StatusBadRequest, errorResponse{apiError{
 			Type:        7,
@@ -170,6 +173,8 @@ func PutLightState(w http.ResponseWriter, r *http.Request, user, light string) {
 
 	log.Printf("Got new light state:\n%+v", string(buf))
 
+	// l is only compared against; the setters queue the changed fields, which are merged into the
+	// stored light by config.Update, so concurrent changes like the reachable state are kept
 	var resp []any
 
 	if newLightState.On != l.State.On {
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"errors"
+	"homeserver/config"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestPutLightStateConcurrent(t *testing.T) {
+	defer config.SetStore(config.SetStore(config.NewMemoryStore()))
+	config.SetInt("deviceRate", 1000)
+	defer config.SetInt("deviceRate", 0)
+	if err := config.Save(USERS, "tester", &userInfo{}); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	// a put and a health flip of the same light must both be stored, whichever comes first
+	for i := 0; i < 20; i++ {
+		id := strconv.Itoa(100 + i)
+		device := newFakeDevice(id)
+		saveFakeLight(t, id, LightState{On: false, Brightness: 100, Reachable: true})
+
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("PUT", "/api/tester/lights/"+id+"/state", strings.NewReader(`{"on": true, "bri": 200}`))
+			PutLightState(w, r, "tester", id)
+			if w.Code != 200 {
+				t.Errorf("PutLightState() status want: 200; got %d", w.Code)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < unreachableAfter; j++ {
+				l, err := LightFromID(id)
+				if err != nil {
+					t.Errorf("LightFromID() error = %v", err)
+					return
+				}
+				l.updateReachable(errors.New("timeout"))
+			}
+		}()
+		wg.Wait()
+		waitSent(t, device, 1)
+		waitQueue(t, id)
+
+		l, err := LightFromID(id)
+		if err != nil {
+			t.Fatalf("LightFromID() error = %v", err)
+		}
+		if !l.State.On || l.State.Brightness != 200 || l.State.Reachable {
+			t.Errorf("light %s want: {on: true, bri: 200, reachable: false}; got %+v", id, l.State)
+		}
+	}
+}
@@ -0,0 +1,318 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"homeserver/config"
+	"homeserver/home/driver"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type cachedDriver struct {
+	config string
+	driver driver.Driver
+}
+
+var (
+	driverMu sync.Mutex
+	drivers  = make(map[string]cachedDriver)
+)
+
+// driver returns the driver of l, or nil if l has no driver configured. Drivers are cached per
+// light and only recreated when the driver config of the light changes.
+func (l *Light) driver() (driver.Driver, error) {
+	if l.Driver == nil {
+		return nil, nil
+	}
+	buf, err := json.Marshal(l.Driver)
+	if err != nil {
+		return nil, err
+	}
+
+	driverMu.Lock()
+	defer driverMu.Unlock()
+	if c, ok := drivers[l.ID()]; ok && c.config == string(buf) {
+		return c.driver, nil
+	}
+	// the old driver may hold resources the new one needs, like the dmx universe
+	closeDriver(l.ID())
+	d, err := driver.New(l.Driver)
+	if err != nil {
+		return nil, err
+	}
+	if n, ok := d.(driver.Notifier); ok {
+		id := l.ID()
+		n.Notify(func(ds driver.State) {
+			if err := IngestState(id, ds); err != nil {
+				log.Printf("ERROR: could not ingest state of light %s: %+v", id, err)
+			}
+		})
+	}
+	drivers[l.ID()] = cachedDriver{string(buf), d}
+	return d, nil
+}
+
+// closeDriver removes the cached driver of the light with the given id and releases its resources.
+// driverMu must be held.
+func closeDriver(id string) {
+	c, ok := drivers[id]
+	if !ok {
+		return
+	}
+	delete(drivers, id)
+	if closer, ok := c.driver.(driver.Closer); ok {
+		if err := closer.Close(); err != nil {
+			log.Printf("ERROR: could not close driver of light %s: %+v", id, err)
+		}
+	}
+}
+
+// Apply sends the current state of l to its device. Lights without a driver are only stored.
+func (l *Light) Apply() error {
+	d, err := l.driver()
+	if err != nil || d == nil {
+		return err
+	}
+	markApplied(l.ID())
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return d.SetState(ctx, l.State.driverState())
+}
+
+func (s LightState) driverState() driver.State {
+	return driver.State{
+		On:               s.On,
+		Brightness:       s.Brightness,
+		Hue:              s.Hue,
+		Saturation:       s.Saturation,
+		ColorTemperature: s.ColorTemperature,
+		XY:               s.XY,
+		ColorMode:        string(s.ColorMode),
+	}
+}
+
+// absorb updates s with the state ds read from the device. Colors are only taken over if they
+// differ visibly, so the device does not overwrite e.g. an xy color with its rgb approximation.
+// absorb reports whether s has changed.
+func (s *LightState) absorb(t LightType, ds driver.State) (changed bool) {
+	if s.On != ds.On {
+		s.On = ds.On
+		changed = true
+	}
+	if t != LightTypeOnOff && ds.Brightness > 0 && s.Brightness != ds.Brightness {
+		s.Brightness = ds.Brightness
+		changed = true
+	}
+	if t == LightTypeOnOff || t == LightTypeDimmable || ds.ColorMode == "" {
+		return changed
+	}
+	if !similarColor(s.driverState(), ds) {
+		s.ColorMode = LightStateColorMode(ds.ColorMode)
+		s.Hue = ds.Hue
+		s.Saturation = ds.Saturation
+		s.ColorTemperature = ds.ColorTemperature
+		s.XY = ds.XY
+		changed = true
+	}
+	return changed
+}
+
+func similarColor(a, b driver.State) bool {
+	const tolerance = 3
+	ar, ag, ab := a.RGB()
+	br, bg, bb := b.RGB()
+	diff := func(x, y uint8) bool { return int(x)-int(y) > tolerance || int(y)-int(x) > tolerance }
+	return !diff(ar, br) && !diff(ag, bg) && !diff(ab, bb)
+}
+
+// SyncLightStates polls the state of every light with a driver each interval and stores changes
+// that were made directly on the device. SyncLightStates blocks until ctx is done.
+func SyncLightStates(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			syncLightStates(ctx)
+		}
+	}
+}
+
+func syncLightStates(ctx context.Context) {
+	lights, err := AllLights()
+	if err != nil {
+		log.Printf("ERROR: could not load lights for state sync: %+v", err)
+		return
+	}
+	for _, l := range lights {
+		d, err := l.driver()
+		if err != nil {
+			log.Printf("ERROR: could not get driver of light '%s': %+v", l.Name, err)
+			continue
+		} else if d == nil {
+			continue
+		}
+
+		reqCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		ds, err := d.State(reqCtx)
+		cancel()
+		// reachability is tracked by MonitorHealth
+		if errors.Is(err, driver.ErrNoState) || errors.Is(err, driver.ErrUnreachable) {
+			continue
+		} else if err != nil {
+			log.Printf("ERROR: could not read state of light '%s': %+v", l.Name, err)
+			continue
+		}
+		if err = IngestState(l.ID(), ds); err != nil {
+			log.Printf("ERROR: could not ingest state of light '%s': %+v", l.Name, err)
+		}
+	}
+}
+
+var (
+	scanMu   sync.Mutex
+	lastScan string = "none"
+	newFound map[string]string
+)
+
+// ScanNewLights searches the network for devices of all drivers supporting discovery and adds the
+// ones not known yet to the lights file.
+func ScanNewLights(ctx context.Context) {
+	scanMu.Lock()
+	if lastScan == "active" {
+		scanMu.Unlock()
+		return
+	}
+	lastScan = "active"
+	newFound = make(map[string]string)
+	scanMu.Unlock()
+
+	found := make(map[string]string)
+	defer func() {
+		scanMu.Lock()
+		lastScan = time.Now().UTC().Format("2006-01-02T15:04:05")
+		newFound = found
+		scanMu.Unlock()
+	}()
+
+	devices := driver.Discover(ctx)
+	var err error
+	if found, err = ImportLights(devices); err != nil {
+		log.Printf("ERROR: could not add found lights: %+v", err)
+	}
+}
+
+// ImportLights adds all devices, whose unique id is not known yet, as new lights to the lights file.
+// It returns the ids and names of the added lights.
+func ImportLights(devices []driver.Device) (map[string]string, error) {
+	added := make(map[string]string)
+	// assign ids and save within one batch, so concurrent imports can not pick the same id
+	err := config.Batch(LIGHTS, func(tx *config.Tx) error {
+		known := make(map[string]bool)
+		nextID := 1
+		for _, id := range tx.Keys() {
+			var l Light
+			if err := tx.Load(id, &l); err != nil {
+				return fmt.Errorf("could not load light '%s': %v", id, err)
+			}
+			known[l.UniqueID] = true
+			if n, err := strconv.Atoi(id); err == nil && n >= nextID {
+				nextID = n + 1
+			}
+		}
+
+		sort.Slice(devices, func(i, j int) bool { return devices[i].UniqueID < devices[j].UniqueID })
+		for _, d := range devices {
+			if d.UniqueID != "" && known[d.UniqueID] {
+				continue
+			}
+			known[d.UniqueID] = true
+
+			l := &Light{
+				State:            LightState{Brightness: 254, Reachable: true, Alert: "none", Mode: "homeautomation"},
+				Type:             lightTypeOf(d.Type),
+				Name:             d.Name,
+				ModelID:          d.ModelID,
+				ManufacturerName: d.ManufacturerName,
+				Productname:      d.ProductName,
+				UniqueID:         d.UniqueID,
+				Driver:           d.Config,
+			}
+			id := strconv.Itoa(nextID)
+			nextID++
+			if err := tx.Save(id, l); err != nil {
+				return fmt.Errorf("could not save new light '%s': %v", l.Name, err)
+			}
+			added[id] = l.Name
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for id, name := range added {
+		log.Printf("Added new light '%s' (%s)", name, id)
+	}
+	return added, nil
+}
+
+func PostNewLights(w http.ResponseWriter, r *http.Request, user string) {
+	if !verifyUser(w, user) {
+		return
+	}
+
+	go ScanNewLights(context.Background())
+
+	buf, err := json.Marshal([]any{successResponse{map[string]string{"/lights": "Searching for new devices"}}})
+	if err != nil {
+		log.Printf("Error: could not marshal search response: %+v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Write(buf)
+}
+
+func GetNewLights(w http.ResponseWriter, r *http.Request, user string) {
+	if !verifyUser(w, user) {
+		return
+	}
+
+	resp := make(map[string]any)
+	scanMu.Lock()
+	for id, name := range newFound {
+		resp[id] = map[string]string{"name": name}
+	}
+	resp["lastscan"] = lastScan
+	scanMu.Unlock()
+
+	buf, err := json.Marshal(resp)
+	if err != nil {
+		log.Printf("Error: could not marshal new lights response: %+v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Write(buf)
+}
+
+// verifyUser checks if user is a registered user and responds with an error if not.
+func verifyUser(w http.ResponseWriter, user string) bool {
+	u := &userInfo{}
+	if err := config.Load(USERS, user, u); err != nil {
+		log.Printf("Error: could not get user: %+v", err)
+		respondError(w, http.StatusBadRequest, errorResponse{apiError{
+			Type:        7,
+			Address:     "/username/",
+			Description: fmt.Sprintf("invalid value, %s, for parameter, username", user),
+		}})
+		return false
+	}
+	return true
+}
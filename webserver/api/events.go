@@ -0,0 +1,86 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Event is a change of the bridge or one of its lights, sent to all subscribers.
+type Event struct {
+	Type  string    `json:"type"`
+	Light string    `json:"light,omitempty"`
+	Data  any       `json:"data,omitempty"`
+	Time  time.Time `json:"time"`
+}
+
+var (
+	eventsMu    sync.Mutex
+	subscribers = make(map[chan Event]bool)
+)
+
+// Publish sends e to all subscribers. Subscribers, that are not fast enough, miss the event.
+func Publish(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	eventsMu.Lock()
+	defer eventsMu.Unlock()
+	for ch := range subscribers {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// Subscribe returns a channel receiving all published events. The returned function must be called
+// to unsubscribe.
+func Subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, 32)
+	eventsMu.Lock()
+	subscribers[ch] = true
+	eventsMu.Unlock()
+	return ch, func() {
+		eventsMu.Lock()
+		delete(subscribers, ch)
+		eventsMu.Unlock()
+	}
+}
+
+// GetEvents streams all events as server-sent events until the client disconnects.
+func GetEvents(w http.ResponseWriter, r *http.Request, user string) {
+	if !verifyUser(w, user) {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	events, unsubscribe := Subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e := <-events:
+			buf, err := json.Marshal(e)
+			if err != nil {
+				log.Printf("Error: could not marshal event: %+v", err)
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, buf)
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"homeserver/config"
+	"homeserver/home/driver"
+	"sync"
+	"time"
+)
+
+const (
+	// unreachableAfter is the number of failed checks in a row before a light is unreachable.
+	unreachableAfter = 3
+	// reachableAfter is the number of successful checks in a row before a light is reachable again.
+	reachableAfter = 2
+)
+
+type lightHealth struct {
+	failures  int
+	successes int
+}
+
+var (
+	healthMu sync.Mutex
+	health   = make(map[string]*lightHealth)
+)
+
+// MonitorHealth checks the device of every light with a driver each interval and updates the
+// reachable state of the light. A single failed or successful check does not change the state, so
+// flapping devices don't flood the log and events. MonitorHealth blocks until ctx is done.
+func MonitorHealth(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			checkHealth(ctx)
+		}
+	}
+}
+
+func checkHealth(ctx context.Context) {
+	lights, err := AllLights()
+	if err != nil {
+		log.Printf("ERROR: could not load lights for health check: %+v", err)
+		return
+	}
+
+	var wg sync.WaitGroup
+	for _, l := range lights {
+		d, err := l.driver()
+		if err != nil || d == nil {
+			continue
+		}
+		wg.Add(1)
+		go func(l *Light, d driver.Driver) {
+			defer wg.Done()
+			probeCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+			err := probe(probeCtx, d)
+			cancel()
+			l.updateReachable(err)
+		}(l, d)
+	}
+	wg.Wait()
+}
+
+// probe checks if the device of d is reachable. Drivers can provide their own check by
+// implementing driver.Prober, otherwise the state of the device is read.
+func probe(ctx context.Context, d driver.Driver) error {
+	if p, ok := d.(driver.Prober); ok {
+		return p.Probe(ctx)
+	}
+	_, err := d.State(ctx)
+	if errors.Is(err, driver.ErrNoState) {
+		return nil
+	}
+	return err
+}
+
+// updateReachable records the result of a health check and flips the reachable state of l once
+// enough checks in a row had the same result.
+func (l *Light) updateReachable(err error) {
+	healthMu.Lock()
+	h, ok := health[l.ID()]
+	if !ok {
+		h = &lightHealth{}
+		health[l.ID()] = h
+	}
+	if err != nil {
+		h.failures++
+		h.successes = 0
+	} else {
+		h.successes++
+		h.failures = 0
+	}
+	flip := (l.State.Reachable && h.failures >= unreachableAfter) ||
+		(!l.State.Reachable && h.successes >= reachableAfter)
+	healthMu.Unlock()
+
+	if !flip {
+		return
+	}
+	reachable := !l.State.Reachable
+	saveErr := config.Update(LIGHTS, l.ID(), l, func(exists bool) error {
+		if !exists {
+			return fmt.Errorf("light '%s' does not exist", l.ID())
+		}
+		l.State.Reachable = reachable
+		return nil
+	})
+	if saveErr != nil {
+		log.Printf("ERROR: could not save reachability of light '%s': %+v", l.Name, saveErr)
+		return
+	}
+	if l.State.Reachable {
+		log.Printf("Light '%s' is reachable again", l.Name)
+	} else {
+		log.Printf("Light '%s' is not reachable: %+v", l.Name, err)
+	}
+	Publish(Event{Type: "reachable", Light: l.ID(), Data: l.State.Reachable})
+}
@@ -0,0 +1,163 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"homeserver/config"
+	"homeserver/home/driver"
+	"sync"
+	"testing"
+	"time"
+)
+
+func init() {
+	driver.Register("fake", func(c driver.Config) (driver.Driver, error) {
+		name, _ := c["device"].(string)
+		return fakeDriver(name), nil
+	})
+}
+
+// fakeDevice is a device of the "fake" driver used by the tests.
+type fakeDevice struct {
+	mu    sync.Mutex
+	state driver.State
+	err   error
+	// sent are all states set, in order, and sentAt the times they were set
+	sent   []driver.State
+	sentAt []time.Time
+}
+
+var (
+	fakeDevicesMu sync.Mutex
+	fakeDevices   = make(map[string]*fakeDevice)
+)
+
+// newFakeDevice replaces the fake device with the given name by a new one.
+func newFakeDevice(name string) *fakeDevice {
+	d := &fakeDevice{}
+	fakeDevicesMu.Lock()
+	fakeDevices[name] = d
+	fakeDevicesMu.Unlock()
+	return d
+}
+
+func (d *fakeDevice) setErr(err error) {
+	d.mu.Lock()
+	d.err = err
+	d.mu.Unlock()
+}
+
+func (d *fakeDevice) sentStates() []driver.State {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]driver.State(nil), d.sent...)
+}
+
+// fakeDriver looks up its device on every call, so tests can replace devices of cached drivers.
+type fakeDriver string
+
+func (f fakeDriver) device() *fakeDevice {
+	fakeDevicesMu.Lock()
+	defer fakeDevicesMu.Unlock()
+	return fakeDevices[string(f)]
+}
+
+func (f fakeDriver) SetState(ctx context.Context, s driver.State) error {
+	d := f.device()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.err != nil {
+		return d.err
+	}
+	d.state = s
+	d.sent = append(d.sent, s)
+	d.sentAt = append(d.sentAt, time.Now())
+	return nil
+}
+
+func (f fakeDriver) State(ctx context.Context) (driver.State, error) {
+	d := f.device()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.state, d.err
+}
+
+// saveFakeLight stores a dimmable light with the given id driven by the fake device with the same
+// name.
+func saveFakeLight(t *testing.T, id string, state LightState) *Light {
+	t.Helper()
+	l := &Light{
+		index:    id,
+		State:    state,
+		Type:     LightTypeDimmable,
+		Name:     "Lamp " + id,
+		UniqueID: "fake:" + id,
+		Driver:   driver.Config{"type": "fake", "device": id},
+	}
+	if err := config.Save(LIGHTS, id, l); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	return l
+}
+
+func TestHealthDebounce(t *testing.T) {
+	failed := errors.New("no route to host")
+	tests := []struct {
+		name      string
+		reachable bool
+		checks    []error
+		want      bool
+		// wantEvents are the reachable states published, in order
+		wantEvents []bool
+	}{
+		{"single failure", true, []error{failed}, true, nil},
+		{"two failures", true, []error{failed, failed}, true, nil},
+		{"three failures", true, []error{failed, failed, failed}, false, []bool{false}},
+		{"failures interrupted", true, []error{failed, failed, nil, failed, failed}, true, nil},
+		{"more failures", true, []error{failed, failed, failed, failed, failed}, false, []bool{false}},
+		{"single success", false, []error{nil}, false, nil},
+		{"two successes", false, []error{nil, nil}, true, []bool{true}},
+		{"successes interrupted", false, []error{nil, failed, nil}, false, nil},
+		{"flapping", true, []error{failed, failed, failed, nil, nil, failed}, true, []bool{false, true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer config.SetStore(config.SetStore(config.NewMemoryStore()))
+			healthMu.Lock()
+			health = make(map[string]*lightHealth)
+			healthMu.Unlock()
+			device := newFakeDevice("1")
+			saveFakeLight(t, "1", LightState{On: true, Brightness: 100, Reachable: tt.reachable})
+
+			events, unsubscribe := Subscribe()
+			defer unsubscribe()
+			for _, err := range tt.checks {
+				device.setErr(err)
+				checkHealth(context.Background())
+			}
+
+			l, err := LightFromID("1")
+			if err != nil {
+				t.Fatalf("LightFromID() error = %v", err)
+			}
+			if l.State.Reachable != tt.want {
+				t.Errorf("reachable want: %v; got %v", tt.want, l.State.Reachable)
+			}
+			var got []bool
+			for len(events) > 0 {
+				if e := <-events; e.Type == "reachable" && e.Light == "1" {
+					got = append(got, e.Data.(bool))
+				}
+			}
+			if len(got) != len(tt.wantEvents) {
+				t.Fatalf("reachable events want: %v; got %v", tt.wantEvents, got)
+			}
+			for i := range got {
+				if got[i] != tt.wantEvents[i] {
+					t.Errorf("reachable events want: %v; got %v", tt.wantEvents, got)
+					break
+				}
+			}
+		})
+	}
+}
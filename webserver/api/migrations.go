@@ -0,0 +1,51 @@
+package api
+
+import (
+	"encoding/json"
+	"homeserver/config"
+)
+
+func init() {
+	config.RegisterMigration(LIGHTS, 1, fixDimmableType)
+	config.RegisterMigration(LIGHTS, 2, addUniqueIDs)
+}
+
+// fixDimmableType replaces the type "Dimable light", which was used in the example lights, with the
+// correct "Dimmable light".
+func fixDimmableType(tx *config.Tx) error {
+	return updateLights(tx, func(key string, l map[string]any) {
+		if l["type"] == "Dimable light" {
+			l["type"] = string(LightTypeDimmable)
+		}
+	})
+}
+
+// addUniqueIDs gives every light without a unique id one derived from its id, as hue clients tell
+// lights apart by their unique id.
+func addUniqueIDs(tx *config.Tx) error {
+	return updateLights(tx, func(key string, l map[string]any) {
+		if id, _ := l["uniqueid"].(string); id == "" {
+			l["uniqueid"] = "homeserver-" + key
+		}
+	})
+}
+
+// updateLights calls f with the key and every light decoded as a generic map, so fields unknown to
+// the current Light type survive. ValidateLights ignores such fields. Only changed lights are saved.
+func updateLights(tx *config.Tx, f func(key string, l map[string]any)) error {
+	for _, key := range tx.Keys() {
+		var l map[string]any
+		if err := tx.Load(key, &l); err != nil {
+			return err
+		}
+		before, _ := json.Marshal(l)
+		f(key, l)
+		if after, _ := json.Marshal(l); string(after) == string(before) {
+			continue
+		}
+		if err := tx.Save(key, l); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"homeserver/config"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func loadFixture(t *testing.T, collection string, version int) map[string]json.RawMessage {
+	buf, err := os.ReadFile(filepath.Join("testdata", "migrations", fmt.Sprintf("%s.v%d.json", collection, version)))
+	if err != nil {
+		t.Fatalf("could not read fixture: %v", err)
+	}
+	data := make(map[string]json.RawMessage)
+	if err = json.Unmarshal(buf, &data); err != nil {
+		t.Fatalf("could not parse fixture: %v", err)
+	}
+	return data
+}
+
+// equalJSON reports whether a and b hold the same values, ignoring formatting and key order.
+func equalJSON(a, b map[string]json.RawMessage) bool {
+	var va, vb any
+	bufA, _ := json.Marshal(a)
+	bufB, _ := json.Marshal(b)
+	json.Unmarshal(bufA, &va)
+	json.Unmarshal(bufB, &vb)
+	return reflect.DeepEqual(va, vb)
+}
+
+func TestMigrateLights(t *testing.T) {
+	latest := config.LatestVersion(LIGHTS)
+	want := loadFixture(t, LIGHTS, latest)
+	if errs := ValidateLights(want); errs != nil {
+		t.Fatalf("fixture of version %d is invalid: %v", latest, errs)
+	}
+
+	for version := 0; version < latest; version++ {
+		t.Run(fmt.Sprintf("from version %d", version), func(t *testing.T) {
+			fixture := loadFixture(t, LIGHTS, version)
+			s := config.NewMemoryStore()
+			for k, v := range fixture {
+				s.Save(LIGHTS, k, v)
+			}
+			if version > 0 {
+				s.Save(LIGHTS, config.VersionKey, version)
+			}
+
+			if err := config.RunMigrations(s); err != nil {
+				t.Fatalf("RunMigrations() error = %v", err)
+			}
+			got := make(map[string]json.RawMessage)
+			s.LoadAll(LIGHTS, &got)
+			if !equalJSON(got, want) {
+				t.Errorf("RunMigrations() want: %s; got %s", want, got)
+			}
+			if v, _ := config.SchemaVersion(s, LIGHTS); v != latest {
+				t.Errorf("SchemaVersion() want: %v; got %v", latest, v)
+			}
+			restore := config.SetStore(s)
+			err := CheckLights(true)
+			config.SetStore(restore)
+			if err != nil {
+				t.Errorf("CheckLights() after RunMigrations() error = %v", err)
+			}
+
+			backup := make(map[string]json.RawMessage)
+			s.LoadAll(fmt.Sprintf("%s.v%d", LIGHTS, version), &backup)
+			if !equalJSON(backup, fixture) {
+				t.Errorf("RunMigrations() backup want: %s; got %s", fixture, backup)
+			}
+		})
+	}
+}
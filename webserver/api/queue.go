@@ -0,0 +1,214 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"homeserver/config"
+	"sync"
+	"time"
+)
+
+// stateChange holds the state fields requested for a light. Fields not requested are nil, so
+// storing a change keeps everything else, like the reachable state or changes made on the device.
+type stateChange struct {
+	On               *bool
+	Brightness       *int
+	Hue              *int
+	Saturation       *int
+	ColorTemperature *int
+}
+
+// merge returns c with the fields requested by next replacing its own.
+func (c stateChange) merge(next stateChange) stateChange {
+	if next.On != nil {
+		c.On = next.On
+	}
+	if next.Brightness != nil {
+		c.Brightness = next.Brightness
+	}
+	if next.Hue != nil {
+		c.Hue = next.Hue
+	}
+	if next.Saturation != nil {
+		c.Saturation = next.Saturation
+	}
+	if next.ColorTemperature != nil {
+		c.ColorTemperature = next.ColorTemperature
+	}
+	return c
+}
+
+// apply sets the requested fields of s.
+func (c stateChange) apply(s *LightState) {
+	if c.On != nil {
+		s.On = *c.On
+	}
+	if c.Brightness != nil {
+		s.Brightness = *c.Brightness
+	}
+	if c.Hue != nil {
+		s.Hue = *c.Hue
+	}
+	if c.Saturation != nil {
+		s.Saturation = *c.Saturation
+	}
+	if c.ColorTemperature != nil {
+		s.ColorTemperature = *c.ColorTemperature
+	}
+}
+
+// lightQueue holds the state changes requested for a light until they are stored and sent to the
+// device. Requests arriving meanwhile are merged into the pending change, so a burst of requests
+// results in a single write with the final state.
+type lightQueue struct {
+	id      string
+	mu      sync.Mutex
+	pending *stateChange
+	// wake is closed by FlushQueues to stop run, which closes done when it returned
+	wake chan struct{}
+	done chan struct{}
+}
+
+var (
+	queuesMu sync.Mutex
+	queues   = make(map[string]*lightQueue)
+	// flushed is set by FlushQueues, changes requested afterwards are stored right away
+	flushed bool
+)
+
+// enqueue schedules change to be stored and sent to the device of l. Every light is sent at most
+// "deviceRate" times per second.
+func (l *Light) enqueue(change stateChange) {
+	id := l.ID()
+	queuesMu.Lock()
+	if flushed {
+		queuesMu.Unlock()
+		flushChange(id, change)
+		return
+	}
+	q, ok := queues[id]
+	if !ok {
+		q = &lightQueue{id: id, wake: make(chan struct{}, 1), done: make(chan struct{})}
+		queues[id] = q
+		go q.run()
+	}
+
+	q.mu.Lock()
+	if q.pending != nil {
+		change = q.pending.merge(change)
+	}
+	// a new pointer, so run can tell whether the change it sent is still the latest
+	q.pending = &change
+	q.mu.Unlock()
+
+	// woken under queuesMu, so FlushQueues does not close wake meanwhile
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	queuesMu.Unlock()
+}
+
+// pendingChange returns the change waiting in the queue of the light with the given id, or nil if
+// there is none.
+func pendingChange(id string) *stateChange {
+	queuesMu.Lock()
+	q, ok := queues[id]
+	queuesMu.Unlock()
+	if !ok {
+		return nil
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.pending
+}
+
+// storeChange applies change to the stored light with the given id and returns the updated light.
+func storeChange(id string, change stateChange) (*Light, error) {
+	l := &Light{index: id}
+	err := config.Update(LIGHTS, id, l, func(exists bool) error {
+		if !exists {
+			return fmt.Errorf("light '%s' does not exist", id)
+		}
+		change.apply(&l.State)
+		return nil
+	})
+	return l, err
+}
+
+func (q *lightQueue) run() {
+	defer close(q.done)
+	for range q.wake {
+		q.mu.Lock()
+		change := q.pending
+		q.mu.Unlock()
+		if change == nil {
+			continue
+		}
+
+		if l, err := storeChange(q.id, *change); err != nil {
+			log.Printf("ERROR: could not save state of light %s: %+v", q.id, err)
+		} else {
+			if err = l.Apply(); err != nil {
+				log.Printf("ERROR: could not apply state to light '%s': %+v", l.Name, err)
+				scheduleRetry(l, err)
+			} else {
+				clearRetry(l.ID())
+			}
+			publishState(l, OriginAPI)
+		}
+
+		q.mu.Lock()
+		if q.pending == change {
+			q.pending = nil
+		}
+		q.mu.Unlock()
+
+		// rate limit; changes arriving meanwhile are merged into the next pending change
+		rate := config.GetInt("deviceRate")
+		if rate <= 0 {
+			rate = 10
+		}
+		time.Sleep(time.Second / time.Duration(rate))
+	}
+}
+
+// errNotSent is the reason of retries for states still queued on shutdown.
+var errNotSent = errors.New("not sent before shutdown")
+
+// FlushQueues stops the queues and stores the changes still waiting in them. They are queued as
+// retries, so they are sent to the devices after a restart. A change being sent is completed first.
+func FlushQueues() {
+	queuesMu.Lock()
+	flushed = true
+	for _, q := range queues {
+		// drop the wakeup, so run returns without sending again
+		select {
+		case <-q.wake:
+		default:
+		}
+		close(q.wake)
+	}
+	queuesMu.Unlock()
+
+	for id, q := range queues {
+		<-q.done
+		q.mu.Lock()
+		change := q.pending
+		q.pending = nil
+		q.mu.Unlock()
+		if change != nil {
+			flushChange(id, *change)
+		}
+	}
+}
+
+// flushChange stores change of the light with the given id and queues it as retry.
+func flushChange(id string, change stateChange) {
+	l, err := storeChange(id, change)
+	if err != nil {
+		log.Printf("ERROR: could not save pending state of light %s: %+v", id, err)
+		return
+	}
+	scheduleRetry(l, errNotSent)
+}
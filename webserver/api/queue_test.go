@@ -0,0 +1,159 @@
+package api
+
+import (
+	"fmt"
+	"homeserver/config"
+	"homeserver/home/driver"
+	"testing"
+	"time"
+)
+
+// waitSent waits until device got n states.
+func waitSent(t *testing.T, device *fakeDevice, n int) []driver.State {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if sent := device.sentStates(); len(sent) >= n {
+			return sent
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("device want %d states; got %v", n, device.sentStates())
+	return nil
+}
+
+// waitQueue waits until the queue of the light with the given id stored and sent all changes.
+func waitQueue(t *testing.T, id string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for pendingChange(id) != nil {
+		if time.Now().After(deadline) {
+			t.Fatalf("queue of light %s still holds %+v", id, pendingChange(id))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestQueueMerge(t *testing.T) {
+	defer config.SetStore(config.SetStore(config.NewMemoryStore()))
+	config.SetInt("deviceRate", 4)
+	defer config.SetInt("deviceRate", 0)
+	device := newFakeDevice("21")
+	l := saveFakeLight(t, "21", LightState{On: false, Brightness: 100, Reachable: true})
+
+	l.On()
+	waitSent(t, device, 1)
+	// sent at once after the rate limit, merged into one change
+	l.Brightness(50)
+	l.Off()
+	l.Brightness(80)
+	c := pendingChange("21")
+	if c == nil || c.On == nil || *c.On || c.Brightness == nil || *c.Brightness != 80 || c.Hue != nil {
+		t.Errorf("pendingChange() want: {on: false, bri: 80}; got %+v", c)
+	}
+	// changes of other fields meanwhile are kept
+	health := &Light{index: "21"}
+	config.Update(LIGHTS, "21", health, func(exists bool) error {
+		health.State.Reachable = false
+		return nil
+	})
+
+	sent := waitSent(t, device, 2)
+	time.Sleep(300 * time.Millisecond)
+	if sent = device.sentStates(); len(sent) != 2 {
+		t.Fatalf("device want 2 states; got %v", sent)
+	}
+	if want := (driver.State{On: true, Brightness: 100}); sent[0] != want {
+		t.Errorf("first state want: %+v; got %+v", want, sent[0])
+	}
+	if want := (driver.State{On: false, Brightness: 80}); sent[1] != want {
+		t.Errorf("final state want: %+v; got %+v", want, sent[1])
+	}
+	stored, err := LightFromID("21")
+	if err != nil {
+		t.Fatalf("LightFromID() error = %v", err)
+	}
+	if want := (LightState{On: false, Brightness: 80, Reachable: false}); stored.State.On != want.On ||
+		stored.State.Brightness != want.Brightness || stored.State.Reachable != want.Reachable {
+		t.Errorf("stored state want: %+v; got %+v", want, stored.State)
+	}
+	if c := pendingChange("21"); c != nil {
+		t.Errorf("pendingChange() after sending want: nil; got %+v", c)
+	}
+}
+
+func TestQueueRate(t *testing.T) {
+	defer config.SetStore(config.SetStore(config.NewMemoryStore()))
+	for _, rate := range []int{5, 20} {
+		t.Run(fmt.Sprintf("%d per second", rate), func(t *testing.T) {
+			config.SetInt("deviceRate", rate)
+			defer config.SetInt("deviceRate", 0)
+			id := fmt.Sprint(30 + rate)
+			device := newFakeDevice(id)
+			l := saveFakeLight(t, id, LightState{On: false, Brightness: 100, Reachable: true})
+
+			for i := 1; i <= 4; i++ {
+				l.Brightness(100 + i)
+				waitSent(t, device, i)
+			}
+			device.mu.Lock()
+			sentAt := device.sentAt
+			device.mu.Unlock()
+			// allow for the resolution of timers
+			min := time.Second/time.Duration(rate) - 5*time.Millisecond
+			for i := 1; i < len(sentAt); i++ {
+				if d := sentAt[i].Sub(sentAt[i-1]); d < min {
+					t.Errorf("state %d sent after %s; want at least %s", i+1, d, min)
+				}
+			}
+		})
+	}
+}
+
+func TestFlushQueues(t *testing.T) {
+	defer config.SetStore(config.SetStore(config.NewMemoryStore()))
+	config.SetInt("deviceRate", 2)
+	defer config.SetInt("deviceRate", 0)
+	resetRetries()
+	defer resetRetries()
+	defer func() {
+		queuesMu.Lock()
+		flushed = false
+		queues = make(map[string]*lightQueue)
+		queuesMu.Unlock()
+	}()
+	device := newFakeDevice("41")
+	l := saveFakeLight(t, "41", LightState{On: false, Brightness: 100, Reachable: true})
+
+	l.On()
+	waitSent(t, device, 1)
+	// waits while the queue sleeps for the rate limit
+	l.Brightness(80)
+	FlushQueues()
+	if sent := device.sentStates(); len(sent) != 1 {
+		t.Errorf("device want 1 state; got %v", sent)
+	}
+	stored, err := LightFromID("41")
+	if err != nil {
+		t.Fatalf("LightFromID() error = %v", err)
+	}
+	if !stored.State.On || stored.State.Brightness != 80 || !stored.State.Reachable {
+		t.Errorf("stored state want: {on: true, bri: 80, reachable: true}; got %+v", stored.State)
+	}
+	retryMu.Lock()
+	c := retries["41"]
+	retryMu.Unlock()
+	if c == nil || c.LastError != errNotSent.Error() || c.State.Brightness != 80 {
+		t.Errorf("retry want: bri 80 not sent before shutdown; got %+v", c)
+	}
+
+	// changes after the flush are stored right away
+	l.Off()
+	if stored, _ = LightFromID("41"); stored.State.On {
+		t.Errorf("stored state after flush want: off; got %+v", stored.State)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if sent := device.sentStates(); len(sent) != 1 {
+		t.Errorf("device want 1 state after flush; got %v", sent)
+	}
+}
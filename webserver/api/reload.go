@@ -0,0 +1,176 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ValidateCollection checks the data of a collection changed by another process, before it
+// replaces the data in use.
+func ValidateCollection(collection string, data map[string]json.RawMessage) error {
+	switch collection {
+	case LIGHTS:
+		var errs []error
+		for _, e := range ValidateLights(data) {
+			errs = append(errs, e)
+		}
+		return errors.Join(errs...)
+	case USERS:
+		for name, raw := range data {
+			var u userInfo
+			if err := json.Unmarshal(raw, &u); err != nil {
+				return fmt.Errorf("user %s: %v", name, err)
+			}
+			if u.Username != name {
+				return fmt.Errorf("user %s: username does not match key", name)
+			}
+		}
+	case RETRIES:
+		for key, raw := range data {
+			var commands map[string]*retryCommand
+			if err := json.Unmarshal(raw, &commands); err != nil {
+				return fmt.Errorf("%s: %v", key, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Reload updates everything derived from a collection after it was changed by another process. For
+// every added, changed or removed light a "light" event is published.
+func Reload(collection string, old, data map[string]json.RawMessage) {
+	switch collection {
+	case LIGHTS:
+		// drivers of changed lights are recreated on their next use
+		driverMu.Lock()
+		for id := range drivers {
+			if _, ok := data[id]; !ok {
+				closeDriver(id)
+			}
+		}
+		driverMu.Unlock()
+
+		for _, id := range changedLights(old, data) {
+			change := "changed"
+			if _, ok := old[id]; !ok {
+				change = "added"
+			} else if _, ok := data[id]; !ok {
+				change = "removed"
+			}
+			log.Printf("Light %s was %s by another process", id, change)
+			Publish(Event{Type: "light", Light: id, Data: change})
+		}
+	case RETRIES:
+		loadRetries()
+	}
+}
+
+// changedLights returns the sorted ids of all lights that differ between old and data.
+func changedLights(old, data map[string]json.RawMessage) []string {
+	var ids []string
+	for id, raw := range data {
+		var before, after Light
+		if prev, ok := old[id]; ok && json.Unmarshal(prev, &before) == nil &&
+			json.Unmarshal(raw, &after) == nil && reflect.DeepEqual(before, after) {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	for id := range old {
+		if _, ok := data[id]; !ok {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// ReloadResult is the outcome of reloading the configuration and data files, e.g. on SIGHUP.
+type ReloadResult struct {
+	Time    time.Time `json:"time"`
+	Success bool      `json:"success"`
+	// Changed are the settings that were changed and are in use now.
+	Changed []string `json:"changed"`
+	// Restart are the settings that were changed, but take effect after a restart only.
+	Restart []string `json:"restart"`
+	Errors  []string `json:"errors"`
+}
+
+var (
+	reloadMu   sync.Mutex
+	reloader   func() ReloadResult
+	lastReload *ReloadResult
+)
+
+// SetReloader sets the function reloading the configuration and data files for RunReload.
+func SetReloader(f func() ReloadResult) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	reloader = f
+}
+
+// RunReload reloads the configuration and data files with the function set by SetReloader. The
+// result is logged and kept for the admin endpoint.
+func RunReload() ReloadResult {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	if reloader == nil {
+		return ReloadResult{Time: time.Now(), Errors: []string{"reloading is not supported"}}
+	}
+	result := reloader()
+	lastReload = &result
+
+	for _, err := range result.Errors {
+		log.Printf("ERROR: reload: %s", err)
+	}
+	if len(result.Restart) > 0 {
+		log.Printf("Reload: changes of %s take effect after a restart", strings.Join(result.Restart, ", "))
+	}
+	if result.Success {
+		log.Printf("Reloaded the configuration, changed: [%s]", strings.Join(result.Changed, ", "))
+	}
+	return result
+}
+
+// ReloadData checks the stored lights and reloads the retry queue, after the data files may have
+// been changed by another process.
+func ReloadData(strict bool) error {
+	loadRetries()
+	return CheckLights(strict)
+}
+
+// GetReload responds with the result of the last reload, or null if there was none.
+func GetReload(w http.ResponseWriter, r *http.Request, user string) {
+	if !verifyUser(w, user) {
+		return
+	}
+	reloadMu.Lock()
+	buf, err := json.Marshal(lastReload)
+	reloadMu.Unlock()
+	writeReload(w, buf, err)
+}
+
+// PostReload reloads the configuration and data files and responds with the result.
+func PostReload(w http.ResponseWriter, r *http.Request, user string) {
+	if !verifyUser(w, user) {
+		return
+	}
+	buf, err := json.Marshal(RunReload())
+	writeReload(w, buf, err)
+}
+
+func writeReload(w http.ResponseWriter, buf []byte, err error) {
+	if err != nil {
+		log.Printf("Error: could not marshal reload response: %+v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Write(buf)
+}
@@ -0,0 +1,200 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"homeserver/config"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	retryMinBackoff = time.Second
+	retryMaxBackoff = 5 * time.Minute
+	// retryMaxShift caps the doubling of the backoff, as the shift overflows after many attempts.
+	retryMaxShift = 16
+)
+
+// retryCommand is a state that could not be sent to the device of a light.
+type retryCommand struct {
+	Light     string     `json:"light"`
+	State     LightState `json:"state"`
+	Attempts  int        `json:"attempts"`
+	Queued    time.Time  `json:"queued"`
+	Next      time.Time  `json:"next"`
+	LastError string     `json:"lasterror"`
+	Failed    bool       `json:"failed"`
+}
+
+var (
+	retryMu sync.Mutex
+	retries = make(map[string]*retryCommand)
+	// retryVersion counts the changes of retries, protected by retryMu
+	retryVersion uint64
+
+	retrySaveMu sync.Mutex
+	// retrySaved is the version of the last saved snapshot, protected by retrySaveMu
+	retrySaved uint64
+)
+
+// retryBackoff returns how long to wait before sending a command again after it failed attempts
+// times.
+func retryBackoff(attempts int) time.Duration {
+	return min(retryMinBackoff<<min(attempts-1, retryMaxShift), retryMaxBackoff)
+}
+
+// scheduleRetry queues the state of l to be sent again after it failed with err. Only the latest
+// state of a light is kept.
+func scheduleRetry(l *Light, err error) {
+	retryMu.Lock()
+	c, ok := retries[l.ID()]
+	if !ok || c.Failed {
+		c = &retryCommand{Light: l.ID(), Queued: time.Now()}
+		retries[l.ID()] = c
+	}
+	c.State = l.State
+	c.Attempts++
+	c.LastError = err.Error()
+	c.Next = time.Now().Add(retryBackoff(c.Attempts))
+
+	maxAge := time.Duration(config.GetInt("retryMaxAge")) * time.Second
+	if maxAge > 0 && time.Since(c.Queued) > maxAge {
+		c.Failed = true
+		log.Printf("ERROR: giving up on light '%s' after %d attempts: %+v", l.Name, c.Attempts, err)
+	}
+	snapshot := snapshotRetries()
+	retryMu.Unlock()
+	saveRetries(snapshot)
+}
+
+// clearRetry removes the queued command of the light with the given id, if any.
+func clearRetry(id string) {
+	retryMu.Lock()
+	if _, ok := retries[id]; !ok {
+		retryMu.Unlock()
+		return
+	}
+	delete(retries, id)
+	snapshot := snapshotRetries()
+	retryMu.Unlock()
+	saveRetries(snapshot)
+}
+
+// retrySnapshot is a copy of the queued commands, to be saved without holding retryMu.
+type retrySnapshot struct {
+	commands map[string]retryCommand
+	version  uint64
+}
+
+// snapshotRetries copies all queued commands. retryMu must be held.
+func snapshotRetries() retrySnapshot {
+	retryVersion++
+	commands := make(map[string]retryCommand, len(retries))
+	for id, c := range retries {
+		commands[id] = *c
+	}
+	return retrySnapshot{commands, retryVersion}
+}
+
+// saveRetries persists snapshot, unless a newer snapshot was saved already.
+func saveRetries(snapshot retrySnapshot) {
+	retrySaveMu.Lock()
+	defer retrySaveMu.Unlock()
+	if snapshot.version <= retrySaved {
+		return
+	}
+	if err := config.Save(RETRIES, "commands", snapshot.commands); err != nil {
+		log.Printf("ERROR: could not save retry queue: %+v", err)
+		return
+	}
+	retrySaved = snapshot.version
+}
+
+// loadRetries takes over the stored commands. They replace the queued commands of the same lights,
+// the others are kept. If the commands can not be loaded, the queued ones stay as they are.
+func loadRetries() {
+	stored := make(map[string]map[string]*retryCommand)
+	if err := config.LoadAll(RETRIES, &stored); err != nil {
+		log.Printf("ERROR: could not load retry queue: %+v", err)
+		return
+	}
+	retryMu.Lock()
+	defer retryMu.Unlock()
+	for id, c := range stored["commands"] {
+		retries[id] = c
+	}
+}
+
+// RunRetries sends queued commands again once their backoff elapsed. Commands queued before a
+// restart are loaded first. RunRetries blocks until ctx is done.
+func RunRetries(ctx context.Context) {
+	loadRetries()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		var due []string
+		retryMu.Lock()
+		for id, c := range retries {
+			if !c.Failed && time.Now().After(c.Next) {
+				due = append(due, id)
+			}
+		}
+		retryMu.Unlock()
+
+		for _, id := range due {
+			// send the latest state, not the one that failed
+			l, err := LightFromID(id)
+			if err != nil {
+				log.Printf("ERROR: dropping retry of unknown light %s: %+v", id, err)
+				clearRetry(id)
+				continue
+			}
+			if err = l.Apply(); err != nil {
+				scheduleRetry(l, err)
+				continue
+			}
+			log.Printf("Light '%s' got its state after retrying", l.Name)
+			clearRetry(id)
+		}
+	}
+}
+
+// GetCommands responds with all pending and failed device commands.
+func GetCommands(w http.ResponseWriter, r *http.Request, user string) {
+	if !verifyUser(w, user) {
+		return
+	}
+
+	retryMu.Lock()
+	resp := struct {
+		Pending []retryCommand `json:"pending"`
+		Failed  []retryCommand `json:"failed"`
+	}{[]retryCommand{}, []retryCommand{}}
+	for _, c := range retries {
+		if c.Failed {
+			resp.Failed = append(resp.Failed, *c)
+		} else {
+			resp.Pending = append(resp.Pending, *c)
+		}
+	}
+	retryMu.Unlock()
+	sort.Slice(resp.Pending, func(i, j int) bool { return resp.Pending[i].Light < resp.Pending[j].Light })
+	sort.Slice(resp.Failed, func(i, j int) bool { return resp.Failed[i].Light < resp.Failed[j].Light })
+
+	buf, err := json.Marshal(resp)
+	if err != nil {
+		log.Printf("Error: could not marshal commands response: %+v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Write(buf)
+}
@@ -0,0 +1,143 @@
+package api
+
+import (
+	"errors"
+	"homeserver/config"
+	"testing"
+	"time"
+)
+
+// resetRetries drops all queued commands.
+func resetRetries() {
+	retryMu.Lock()
+	retries = make(map[string]*retryCommand)
+	retryMu.Unlock()
+}
+
+func TestRetryBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{9, 256 * time.Second},
+		{10, retryMaxBackoff},
+		{64, retryMaxBackoff},
+		{100000, retryMaxBackoff},
+	}
+	for _, tt := range tests {
+		if got := retryBackoff(tt.attempts); got != tt.want {
+			t.Errorf("retryBackoff(%d) want: %v; got %v", tt.attempts, tt.want, got)
+		}
+	}
+
+	defer config.SetStore(config.SetStore(config.NewMemoryStore()))
+	resetRetries()
+	defer resetRetries()
+	l := &Light{index: "1", Name: "Lamp"}
+	for i := 1; i <= 12; i++ {
+		start := time.Now()
+		scheduleRetry(l, errors.New("timeout"))
+		retryMu.Lock()
+		c := *retries["1"]
+		retryMu.Unlock()
+		if c.Attempts != i {
+			t.Errorf("attempts want: %d; got %d", i, c.Attempts)
+		}
+		if wait := c.Next.Sub(start); wait < retryBackoff(i) || wait > retryBackoff(i)+time.Second {
+			t.Errorf("attempt %d next want: in %v; got in %v", i, retryBackoff(i), wait)
+		}
+	}
+}
+
+func TestRetryGiveUp(t *testing.T) {
+	defer config.SetStore(config.SetStore(config.NewMemoryStore()))
+	resetRetries()
+	defer resetRetries()
+	config.SetInt("retryMaxAge", 60)
+	defer config.SetInt("retryMaxAge", 0)
+	l := &Light{index: "1", Name: "Lamp"}
+
+	scheduleRetry(l, errors.New("timeout"))
+	retryMu.Lock()
+	failed := retries["1"].Failed
+	// queued long enough ago to give up on the next failure
+	retries["1"].Queued = time.Now().Add(-61 * time.Second)
+	retryMu.Unlock()
+	if failed {
+		t.Fatalf("command failed after the first attempt")
+	}
+
+	scheduleRetry(l, errors.New("still timeout"))
+	retryMu.Lock()
+	c := *retries["1"]
+	retryMu.Unlock()
+	if !c.Failed || c.Attempts != 2 || c.LastError != "still timeout" {
+		t.Errorf("command want: failed after 2 attempts with 'still timeout'; got %+v", c)
+	}
+
+	// a new failure starts over
+	scheduleRetry(l, errors.New("timeout"))
+	retryMu.Lock()
+	c = *retries["1"]
+	retryMu.Unlock()
+	if c.Failed || c.Attempts != 1 {
+		t.Errorf("new command want: pending after 1 attempt; got %+v", c)
+	}
+}
+
+func TestRetryPersistence(t *testing.T) {
+	defer config.SetStore(config.SetStore(config.NewMemoryStore()))
+	resetRetries()
+	defer resetRetries()
+
+	a := &Light{index: "1", Name: "A", State: LightState{On: true, Brightness: 42}}
+	b := &Light{index: "2", Name: "B"}
+	c := &Light{index: "3", Name: "C"}
+	scheduleRetry(a, errors.New("timeout"))
+	scheduleRetry(a, errors.New("refused"))
+	scheduleRetry(b, errors.New("timeout"))
+	scheduleRetry(c, errors.New("timeout"))
+	clearRetry("3")
+
+	resetRetries()
+	loadRetries()
+	retryMu.Lock()
+	defer retryMu.Unlock()
+	if len(retries) != 2 || retries["3"] != nil {
+		t.Fatalf("loadRetries() want: commands of 1 and 2; got %v", retries)
+	}
+	got := retries["1"]
+	if got.Attempts != 2 || got.LastError != "refused" || !got.State.On || got.State.Brightness != 42 || got.Next.IsZero() {
+		t.Errorf("loadRetries() want: 2 attempts of {on: true, bri: 42}, failed with refused; got %+v", got)
+	}
+}
+
+func TestLoadRetriesMerge(t *testing.T) {
+	store := config.NewMemoryStore()
+	defer config.SetStore(config.SetStore(store))
+	resetRetries()
+	defer resetRetries()
+	scheduleRetry(&Light{index: "1", Name: "A"}, errors.New("timeout"))
+
+	// an unreadable retry file keeps the queue
+	store.Save(RETRIES, "commands", "garbage")
+	loadRetries()
+	retryMu.Lock()
+	kept := retries["1"] != nil
+	retryMu.Unlock()
+	if !kept {
+		t.Errorf("loadRetries() of invalid file dropped the queued command")
+	}
+
+	// stored commands are added to the queued ones
+	store.Save(RETRIES, "commands", map[string]retryCommand{"2": {Light: "2", Attempts: 3}})
+	loadRetries()
+	retryMu.Lock()
+	defer retryMu.Unlock()
+	if len(retries) != 2 || retries["1"] == nil || retries["2"] == nil || retries["2"].Attempts != 3 {
+		t.Errorf("loadRetries() want: commands of 1 and 2; got %v", retries)
+	}
+}
@@ -0,0 +1,82 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"homeserver/config"
+	"homeserver/home/driver"
+	"sync"
+	"time"
+)
+
+// Origin tells where a change of a light state came from. It is published with every state event,
+// so clients can tell their own changes from changes made on the device. Origin does not decide what
+// is sent to the device: IngestState only stores changes observed on the device, so they never
+// travel back to it.
+type Origin string
+
+const (
+	// OriginAPI is a change requested by a client of the hue api.
+	OriginAPI Origin = "api"
+	// OriginDevice is a change observed on the device, e.g. a wall switch or another app.
+	OriginDevice Origin = "device"
+)
+
+// settleTime is how long states reported by a device are ignored after a new state was sent to
+// it. Devices may still report the old state or intermediate states of a transition meanwhile.
+const settleTime = 2 * time.Second
+
+var (
+	appliedMu sync.Mutex
+	appliedAt = make(map[string]time.Time)
+)
+
+func markApplied(id string) {
+	appliedMu.Lock()
+	appliedAt[id] = time.Now()
+	appliedMu.Unlock()
+}
+
+func settling(id string) bool {
+	appliedMu.Lock()
+	defer appliedMu.Unlock()
+	return time.Since(appliedAt[id]) < settleTime
+}
+
+// IngestState stores the state ds observed on the device of the light with the given id, without
+// sending it back to the device. Drivers report states either by being polled or through
+// driver.Notifier.
+func IngestState(id string, ds driver.State) error {
+	if settling(id) || pendingChange(id) != nil {
+		// a requested change is on its way to the device
+		return nil
+	}
+
+	l := &Light{index: id}
+	err := config.Update(LIGHTS, id, l, func(exists bool) error {
+		if !exists {
+			return fmt.Errorf("light '%s' does not exist", id)
+		}
+		l.normalize()
+		if !l.State.absorb(l.Type, ds) {
+			return errUnchanged
+		}
+		return nil
+	})
+	if err == errUnchanged {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	log.Printf("Light '%s' was changed on the device", l.Name)
+	publishState(l, OriginDevice)
+	return nil
+}
+
+// errUnchanged aborts an update of the lights file that would not change anything.
+var errUnchanged = errors.New("unchanged")
+
+func publishState(l *Light, origin Origin) {
+	Publish(Event{Type: "state", Light: l.ID(), Data: map[string]any{"origin": origin, "state": &l.State}})
+}
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"homeserver/config"
+	"homeserver/home/driver"
+	"testing"
+	"time"
+)
+
+func TestIngestState(t *testing.T) {
+	reported := driver.State{On: true, Brightness: 42}
+	tests := []struct {
+		name string
+		// applied is how long ago a state was sent to the device
+		applied time.Duration
+		want    LightState
+		// wantEvent is whether a state event with origin device is published
+		wantEvent bool
+	}{
+		{"inside settle window", settleTime / 2, LightState{On: false, Brightness: 100, Reachable: true}, false},
+		{"after settle window", settleTime + time.Second, LightState{On: true, Brightness: 42, Reachable: true}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer config.SetStore(config.SetStore(config.NewMemoryStore()))
+			device := newFakeDevice("1")
+			saveFakeLight(t, "1", LightState{On: false, Brightness: 100, Reachable: true})
+			appliedMu.Lock()
+			appliedAt["1"] = time.Now().Add(-tt.applied)
+			appliedMu.Unlock()
+			defer func() {
+				appliedMu.Lock()
+				delete(appliedAt, "1")
+				appliedMu.Unlock()
+			}()
+
+			events, unsubscribe := Subscribe()
+			defer unsubscribe()
+			if err := IngestState("1", reported); err != nil {
+				t.Fatalf("IngestState() error = %v", err)
+			}
+
+			l, err := LightFromID("1")
+			if err != nil {
+				t.Fatalf("LightFromID() error = %v", err)
+			}
+			if l.State.On != tt.want.On || l.State.Brightness != tt.want.Brightness {
+				t.Errorf("state want: %+v; got %+v", tt.want, l.State)
+			}
+			var got bool
+			for len(events) > 0 {
+				e := <-events
+				if e.Type == "state" && e.Light == "1" {
+					if origin := e.Data.(map[string]any)["origin"]; origin != OriginDevice {
+						t.Errorf("event origin want: %v; got %v", OriginDevice, origin)
+					}
+					got = true
+				}
+			}
+			if got != tt.wantEvent {
+				t.Errorf("state event want: %v; got %v", tt.wantEvent, got)
+			}
+			if sent := device.sentStates(); len(sent) != 0 {
+				t.Errorf("IngestState() sent %v back to the device", sent)
+			}
+		})
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"homeserver/config"
+	"homeserver/home/driver"
 	"math/rand"
 	"reflect"
 	"strings"
@@ -13,9 +14,11 @@ import (
 	"golang.org/x/exp/slices"
 )
 
+// collections of the config store
 const (
-	USERFILE  string = "config/users.json"
-	LIGHTFILE string = "config/lights.json"
+	USERS   string = "users"
+	LIGHTS  string = "lights"
+	RETRIES string = "retries"
 )
 
 type userInfo struct {
@@ -24,31 +27,38 @@ type userInfo struct {
 }
 
 func newUserFromDisplayname(displayname string) *userInfo {
-	usernames, err := config.JSONKeys(USERFILE)
+	var (
+		user    *userInfo
+		created bool
+	)
+	err := config.Batch(USERS, func(tx *config.Tx) error {
+		for _, un := range tx.Keys() {
+			u := &userInfo{}
+			if err := tx.Load(un, u); err != nil {
+				return fmt.Errorf("could not load user '%s': %v", un, err)
+			}
+			if displayname == u.Displayname {
+				//user already exists
+				user = u
+				return nil
+			}
+		}
+
+		user = &userInfo{getUsername(), displayname}
+		created = true
+		return tx.Save(user.Username, user)
+	})
 	if err != nil {
-		log.Printf("ERROR: could not load usernames from file: %+v", err)
-	}
-	users := make([]*userInfo, len(usernames))
-	for i, un := range usernames {
-		users[i] = &userInfo{}
-		if err = config.JSONLoad(USERFILE, un, users[i]); err != nil {
-			log.Printf("ERROR: could not load user '%s' from file: %+v", un, err)
+		log.Printf("ERROR: could not save user to file: %+v", err)
+		if user == nil {
 			return &userInfo{}
 		}
-		if displayname == users[i].Displayname {
-			//user already exists
-			return users[i]
-		}
+		return user
 	}
-
-	newUsername := getUsername()
-	newUser := &userInfo{newUsername, displayname}
-	if err = config.JSONSave(USERFILE, newUsername, newUser); err != nil {
-		log.Printf("ERROR: could not save user to file: %+v", err)
-		return newUser
+	if created {
+		log.Printf("registered new user %s (%s)", user.Displayname, user.Username)
 	}
-	log.Printf("registered new user %s (%s)", newUser.Displayname, newUser.Username)
-	return newUser
+	return user
 }
 
 // getUsername generates a random username
@@ -69,6 +79,16 @@ type Light struct {
 	Productname      string     `json:"productname,omitempty"`
 	UniqueID         string     `json:"uniqueid,omitempty"`
 	SoftwareVersion  string     `json:"swversion,omitempty"`
+	// Driver selects and configures the driver controlling the device of this light. Lights
+	// without a driver only exist in the lights file.
+	Driver driver.Config `json:"driver,omitempty"`
+}
+
+// public returns a copy of l without the internal settings, to be sent to api clients.
+func (l *Light) public() *Light {
+	p := *l
+	p.Driver = nil
+	return &p
 }
 
 type LightType string
@@ -95,6 +115,24 @@ func (t LightType) getModelID() string {
 	return ""
 }
 
+// lightTypeOf returns the light type closest to the type t reported by a device, e.g. a dimmable
+// light for the "Dimmable plug-in unit" of a Hue bridge. Devices of unknown types are on/off
+// lights, as every device can be switched.
+func lightTypeOf(t string) LightType {
+	lower := strings.ToLower(t)
+	switch {
+	case strings.Contains(lower, "extended color"):
+		return LightTypeExtendedColor
+	case strings.Contains(lower, "color temperature"):
+		return LightTypeColorTemperature
+	case strings.Contains(lower, "color"):
+		return LightTypeColor
+	case strings.Contains(lower, "dimmable"):
+		return LightTypeDimmable
+	}
+	return LightTypeOnOff
+}
+
 type LightStateColorMode string
 
 const (
@@ -117,29 +155,53 @@ type LightState struct {
 }
 
 func AllLights() (lights map[string]*Light, err error) {
-	keys, err := config.JSONKeys(LIGHTFILE)
-	if err != nil {
+	data := make(map[string]json.RawMessage)
+	if err = config.LoadAll(LIGHTS, &data); err != nil {
+		log.Printf("Error: could not load lights from file: %+v", err)
 		return nil, err
 	}
 
-	lights = make(map[string]*Light, len(keys))
-	for _, key := range keys {
-		l, err := LightFromID(key)
-		if err != nil {
-			log.Printf("Error: could not load light '%s', from file: %+v", key, err)
+	invalid := make(map[string][]LightError)
+	for _, e := range ValidateLights(data) {
+		invalid[e.Key] = append(invalid[e.Key], e)
+	}
+	lights = make(map[string]*Light, len(data))
+	for id, raw := range data {
+		if errs, ok := invalid[id]; ok {
+			reportInvalid(id, errs)
+			continue
+		}
+		l := &Light{index: id}
+		if err = json.Unmarshal(raw, l); err != nil {
 			return nil, err
 		}
-		lights[key] = l
+		l.normalize()
+		lights[id] = l
 	}
-
 	return lights, nil
 }
 
 func LightFromID(id string) (*Light, error) {
-	l := &Light{}
-	err := config.JSONLoad(LIGHTFILE, id, l)
+	l := &Light{index: id}
+	var raw json.RawMessage
+	err := config.Load(LIGHTS, id, &raw)
 	if err != nil {
 		err = fmt.Errorf("Error: could not get light '%s': %+v", id, err)
+	} else if _, errs := validateLight(id, raw); errs != nil {
+		reportInvalid(id, errs)
+		err = fmt.Errorf("Error: light '%s' is invalid: %v", id, errs[0])
+	} else {
+		err = json.Unmarshal(raw, l)
+	}
+	l.normalize()
+	return l, err
+}
+
+// normalize overlays pending changes and clears the state values not supported by the light type.
+func (l *Light) normalize() {
+	if c := pendingChange(l.index); c != nil {
+		// show changes not stored yet
+		c.apply(&l.State)
 	}
 	if l.ModelID == "" {
 		l.ModelID = l.Type.getModelID()
@@ -168,8 +230,6 @@ func LightFromID(id string) (*Light, error) {
 		}
 		l.State.ColorTemperature = 0
 	}
-
-	return l, err
 }
 
 // MarshalJSON implements the json.Marshaler interface
@@ -296,10 +356,13 @@ func getFieldByTag(i any, key, tagPrefix string, skip int) (field reflect.Struct
 }
 
 func (l *Light) Save() {
-	config.JSONSave(LIGHTFILE, l.ID(), l)
+	config.Save(LIGHTS, l.ID(), l)
 }
 
 func (l *Light) ID() string {
+	if l.index != "" {
+		return l.index
+	}
 	var lights map[string]*Light
 	var err error
 	if lights, err = AllLights(); err != nil {
@@ -314,37 +377,39 @@ func (l *Light) ID() string {
 }
 
 func (l *Light) On() {
-	l.State.On = true
-	l.Save()
+	on := true
+	l.State.On = on
+	l.enqueue(stateChange{On: &on})
 	log.Printf("Light '%s' turned ON", l.Name)
 }
 func (l *Light) Off() {
-	l.State.On = false
-	l.Save()
+	on := false
+	l.State.On = on
+	l.enqueue(stateChange{On: &on})
 	log.Printf("Light '%s' turned OFF", l.Name)
 }
 
 func (l *Light) Brightness(v int) {
 	l.State.Brightness = v
-	l.Save()
+	l.enqueue(stateChange{Brightness: &v})
 	log.Printf("Light '%s' is set %d%%", l.Name, l.State.Brightness*100/255)
 }
 
 func (l *Light) ColorTemperature(v int) {
 	l.State.ColorTemperature = v
 	// l.State.ColorMode = ColorModeColorTemp
-	l.Save()
+	l.enqueue(stateChange{ColorTemperature: &v})
 	log.Printf("Light '%s' is set to temp: %d", l.Name, v)
 }
 
 func (l *Light) Hue(v int) {
 	l.State.Hue = v
-	l.Save()
+	l.enqueue(stateChange{Hue: &v})
 	log.Printf("Light '%s' is set to hue: %d", l.Name, v)
 }
 
 func (l *Light) Saturation(v int) {
 	l.State.Saturation = v
-	l.Save()
+	l.enqueue(stateChange{Saturation: &v})
 	log.Printf("Light '%s' is set to saturation: %d", l.Name, v)
 }
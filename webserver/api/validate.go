@@ -0,0 +1,184 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"homeserver/config"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/exp/slices"
+)
+
+// LightError is a problem of a stored light, found by ValidateLights.
+type LightError struct {
+	// Key is the id of the light in the lights collection.
+	Key string
+	// Field is the json path of the invalid field, e.g. "state.bri", or empty if the problem
+	// concerns the whole entry.
+	Field   string
+	Message string
+}
+
+func (e LightError) Error() string {
+	if e.Field == "" {
+		return fmt.Sprintf("light '%s': %s", e.Key, e.Message)
+	}
+	return fmt.Sprintf("light '%s': %s: %s", e.Key, e.Field, e.Message)
+}
+
+var lightKey = regexp.MustCompile(`^[1-9][0-9]*$`)
+
+var lightTypes = []LightType{
+	LightTypeOnOff,
+	LightTypeDimmable,
+	LightTypeColorTemperature,
+	LightTypeColor,
+	LightTypeExtendedColor,
+}
+
+// ValidateLights checks all entries of the lights collection. A unique id may be missing, but the
+// ids that are set must be unique. It returns the problems sorted by key, or nil if all lights are
+// valid.
+func ValidateLights(data map[string]json.RawMessage) []LightError {
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var errs []LightError
+	uniqueIDs := make(map[string]string)
+	for _, key := range keys {
+		l, lightErrs := validateLight(key, data[key])
+		errs = append(errs, lightErrs...)
+		if l == nil || l.UniqueID == "" {
+			continue
+		}
+		if other, ok := uniqueIDs[l.UniqueID]; ok {
+			errs = append(errs, LightError{key, "uniqueid", fmt.Sprintf("'%s' is already used by light '%s'", l.UniqueID, other)})
+			continue
+		}
+		uniqueIDs[l.UniqueID] = key
+	}
+	return errs
+}
+
+// validateLight decodes and checks a single entry of the lights collection. The light is nil if
+// the entry could not be decoded.
+func validateLight(key string, raw json.RawMessage) (*Light, []LightError) {
+	var errs []LightError
+	invalid := func(field, format string, a ...any) {
+		errs = append(errs, LightError{key, field, fmt.Sprintf(format, a...)})
+	}
+	if !lightKey.MatchString(key) {
+		invalid("", "key must be a positive number without leading zeros")
+	}
+
+	// fields unknown to Light are ignored, they may be kept for a later migration or be written by
+	// a newer version
+	l := &Light{}
+	if err := json.Unmarshal(raw, l); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			invalid(typeErr.Field, "%s can not be a %s", typeErr.Value, typeErr.Type)
+		} else {
+			invalid("", "%v", err)
+		}
+		return nil, errs
+	}
+
+	if l.Name == "" {
+		invalid("name", "missing")
+	}
+	if !slices.Contains(lightTypes, l.Type) {
+		invalid("type", "unknown light type '%s'", l.Type)
+	}
+	if l.Driver != nil && l.Driver.Type() == "" {
+		invalid("driver.type", "missing")
+	}
+
+	s := l.State
+	inRange := func(field string, v, min, max int) {
+		if v < min || v > max {
+			invalid(field, "%d is out of range %d-%d", v, min, max)
+		}
+	}
+	if s.Brightness != 0 {
+		inRange("state.bri", s.Brightness, 1, 254)
+	}
+	if s.ColorTemperature != 0 {
+		inRange("state.ct", s.ColorTemperature, 153, 500)
+	}
+	inRange("state.hue", s.Hue, 0, 65535)
+	inRange("state.sat", s.Saturation, 0, 254)
+	for i, v := range s.XY {
+		if v < 0 || v > 1 {
+			invalid(fmt.Sprintf("state.xy[%d]", i), "%g is out of range 0-1", v)
+		}
+	}
+	switch s.ColorMode {
+	case "", ColorModeHSV, ColorModeXY, ColorModeColorTemp:
+	default:
+		invalid("state.colormode", "unknown color mode '%s'", s.ColorMode)
+	}
+
+	if errs != nil {
+		return nil, errs
+	}
+	return l, nil
+}
+
+var (
+	reportedMu sync.Mutex
+	// reported holds the problems already logged per light, so they are only logged once
+	reported = make(map[string]string)
+)
+
+// reportInvalid logs the problems of a light skipped because of them, unless they were already
+// logged.
+func reportInvalid(key string, errs []LightError) {
+	msgs := make([]string, len(errs))
+	for i, e := range errs {
+		msgs[i] = e.Error()
+	}
+	msg := strings.Join(msgs, "; ")
+
+	reportedMu.Lock()
+	defer reportedMu.Unlock()
+	if reported[key] == msg {
+		return
+	}
+	reported[key] = msg
+	log.Printf("ERROR: skipping invalid light: %s", msg)
+}
+
+// CheckLights validates all stored lights. In strict mode it returns an error if any light is
+// invalid, otherwise the invalid lights are only logged and skipped from now on.
+func CheckLights(strict bool) error {
+	data := make(map[string]json.RawMessage)
+	if err := config.LoadAll(LIGHTS, &data); err != nil {
+		return err
+	}
+	errs := ValidateLights(data)
+	if len(errs) == 0 {
+		return nil
+	}
+	if strict {
+		for _, e := range errs {
+			log.Printf("ERROR: %v", e)
+		}
+		return fmt.Errorf("%d problems in the stored lights", len(errs))
+	}
+	byKey := make(map[string][]LightError)
+	for _, e := range errs {
+		byKey[e.Key] = append(byKey[e.Key], e)
+	}
+	for key, errs := range byKey {
+		reportInvalid(key, errs)
+	}
+	return nil
+}
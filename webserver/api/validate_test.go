@@ -0,0 +1,144 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"homeserver/config"
+	"homeserver/home/driver"
+	"testing"
+)
+
+func TestValidateLights(t *testing.T) {
+	valid := `{"name": "Lamp", "type": "Extended color light", "uniqueid": "%s", "state": {"on": true, "bri": 254, "hue": 100, "sat": 254, "colormode": "hs"}}`
+	tests := []struct {
+		name string
+		data map[string]string
+		want []LightError
+	}{
+		{
+			name: "valid",
+			data: map[string]string{
+				"1":  `{"name": "A", "type": "Dimmable light", "uniqueid": "a", "state": {"on": false, "bri": 1}}`,
+				"12": `{"name": "B", "type": "Color temperature light", "uniqueid": "b", "state": {"ct": 500, "colormode": "ct"}, "driver": {"type": "wled", "address": "1.2.3.4"}}`,
+			},
+		},
+		{
+			name: "typo in type",
+			data: map[string]string{"1": `{"name": "A", "type": "Dimable light", "uniqueid": "a", "state": {}}`},
+			want: []LightError{{"1", "type", "unknown light type 'Dimable light'"}},
+		},
+		{
+			name: "out of range",
+			data: map[string]string{"1": `{"name": "A", "type": "Extended color light", "uniqueid": "a", "state": {"bri": 255, "ct": 100, "hue": 70000, "sat": -1, "xy": [0.5, 1.5]}}`},
+			want: []LightError{
+				{"1", "state.bri", "255 is out of range 1-254"},
+				{"1", "state.ct", "100 is out of range 153-500"},
+				{"1", "state.hue", "70000 is out of range 0-65535"},
+				{"1", "state.sat", "-1 is out of range 0-254"},
+				{"1", "state.xy[1]", "1.5 is out of range 0-1"},
+			},
+		},
+		{
+			name: "wrong field type",
+			data: map[string]string{"1": `{"name": "A", "type": "Dimmable light", "uniqueid": "a", "state": {"bri": "full"}}`},
+			want: []LightError{{"1", "state.bri", "string can not be a int"}},
+		},
+		{
+			name: "unknown field",
+			data: map[string]string{"1": `{"name": "A", "type": "Dimmable light", "uniqueid": "a", "capabilities": {"certified": false}}`},
+			want: nil,
+		},
+		{
+			name: "missing fields",
+			data: map[string]string{"1": `{"state": {}, "driver": {"address": "x"}}`},
+			want: []LightError{
+				{"1", "name", "missing"},
+				{"1", "type", "unknown light type ''"},
+				{"1", "driver.type", "missing"},
+			},
+		},
+		{
+			name: "bad keys and duplicate unique id",
+			data: map[string]string{
+				"1":   fmt.Sprintf(valid, "same"),
+				"2":   fmt.Sprintf(valid, "same"),
+				"01":  fmt.Sprintf(valid, "other"),
+				"abc": fmt.Sprintf(valid, "another"),
+			},
+			want: []LightError{
+				{"01", "", "key must be a positive number without leading zeros"},
+				{"2", "uniqueid", "'same' is already used by light '1'"},
+				{"abc", "", "key must be a positive number without leading zeros"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := make(map[string]json.RawMessage, len(tt.data))
+			for k, v := range tt.data {
+				data[k] = json.RawMessage(v)
+			}
+			got := ValidateLights(data)
+			if len(got) != len(tt.want) {
+				t.Fatalf("ValidateLights() want: %v; got %v", tt.want, got)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("ValidateLights() want: %v; got %v", tt.want[i], got[i])
+				}
+			}
+		})
+	}
+}
+
+func TestImportLightTypes(t *testing.T) {
+	defer config.SetStore(config.SetStore(config.NewMemoryStore()))
+	tests := []struct {
+		device string
+		want   LightType
+	}{
+		{"On/Off plug-in unit", LightTypeOnOff},
+		{"On/off light", LightTypeOnOff},
+		{"Dimmable plug-in unit", LightTypeDimmable},
+		{"Dimmable light", LightTypeDimmable},
+		{"Color temperature light", LightTypeColorTemperature},
+		{"Color light", LightTypeColor},
+		{"Extended color light", LightTypeExtendedColor},
+		{"Window covering device", LightTypeOnOff},
+	}
+	var devices []driver.Device
+	for i, tt := range tests {
+		devices = append(devices, driver.Device{
+			Name:     tt.device,
+			Type:     tt.device,
+			UniqueID: fmt.Sprintf("import:%02d", i),
+			Config:   driver.Config{"type": "fake", "device": tt.device},
+		})
+	}
+	added, err := ImportLights(devices)
+	if err != nil {
+		t.Fatalf("ImportLights() error = %v", err)
+	}
+	if len(added) != len(tests) {
+		t.Fatalf("ImportLights() want %d lights; got %v", len(tests), added)
+	}
+
+	data := make(map[string]json.RawMessage)
+	config.LoadAll(LIGHTS, &data)
+	if errs := ValidateLights(data); errs != nil {
+		t.Errorf("ValidateLights() of imported lights want: nil; got %v", errs)
+	}
+	lights, err := AllLights()
+	if err != nil {
+		t.Fatalf("AllLights() error = %v", err)
+	}
+	types := make(map[string]LightType)
+	for _, l := range lights {
+		types[l.Name] = l.Type
+	}
+	for _, tt := range tests {
+		if types[tt.device] != tt.want {
+			t.Errorf("type of imported '%s' want: %v; got %v", tt.device, tt.want, types[tt.device])
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"homeserver/home"
 	"homeserver/webserver/api"
 	"html/template"
+	"net"
 	"net/http"
 
 	"github.com/gorilla/mux"
@@ -29,14 +30,19 @@ func handle_discorver_xml(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	mac, _ := net.ParseMAC(config.GetString("macAddr"))
 	data := struct {
-		IP   string
-		Port int
-		Mac  string
+		Name   string
+		IP     string
+		Port   int
+		Serial string
+		UUID   string
 	}{
-		IP:   config.GetString("ip"),
-		Port: config.GetInt("port"),
-		Mac:  config.GetString("macAddr"),
+		Name:   config.GetString("bridgeName"),
+		IP:     config.GetString("ip"),
+		Port:   config.GetInt("port"),
+		Serial: home.BridgeSerial(mac),
+		UUID:   home.BridgeUUID(mac),
 	}
 
 	w.Header().Set("Content-Type", "text/xml")
@@ -84,15 +90,19 @@ func handleLights(w http.ResponseWriter, r *http.Request) {
 }
 
 func handleNewLights(w http.ResponseWriter, r *http.Request) {
+	urlVars := mux.Vars(r)
 	switch r.Method {
 	case http.MethodGet:
-		w.WriteHeader(http.StatusNotImplemented)
-		return
+		api.GetNewLights(w, r, urlVars["user"])
 	case http.MethodPost:
 		if err := home.CloseSmartDeviceAdvertiser(); err != nil {
 			log.Printf("ERROR: could not close Smart Device Advertiser: %+v", err)
 		}
 		home.AdvertiseSmartDevices()
+		api.PostNewLights(w, r, urlVars["user"])
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
 	}
 }
 
@@ -121,3 +131,38 @@ func handleLightState(w http.ResponseWriter, r *http.Request) {
 	}
 
 }
+
+func handleEvents(w http.ResponseWriter, r *http.Request) {
+	urlVars := mux.Vars(r)
+	switch r.Method {
+	case http.MethodGet:
+		api.GetEvents(w, r, urlVars["user"])
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+}
+
+func handleReload(w http.ResponseWriter, r *http.Request) {
+	urlVars := mux.Vars(r)
+	switch r.Method {
+	case http.MethodGet:
+		api.GetReload(w, r, urlVars["user"])
+	case http.MethodPost:
+		api.PostReload(w, r, urlVars["user"])
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+}
+
+func handleCommands(w http.ResponseWriter, r *http.Request) {
+	urlVars := mux.Vars(r)
+	switch r.Method {
+	case http.MethodGet:
+		api.GetCommands(w, r, urlVars["user"])
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+}
@@ -0,0 +1,65 @@
+package webserver
+
+import (
+	"encoding/json"
+	"homeserver/config"
+	"homeserver/webserver/api"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAdminReload(t *testing.T) {
+	store := config.NewMemoryStore()
+	defer config.SetStore(config.SetStore(store))
+	store.Save(api.USERS, "testuser", map[string]any{"username": "testuser", "devicetype": "test"})
+
+	calls := 0
+	want := api.ReloadResult{
+		Time:    time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC),
+		Success: true,
+		Changed: []string{"bridge_name"},
+		Restart: []string{"port"},
+		Errors:  []string{},
+	}
+	api.SetReloader(func() api.ReloadResult {
+		calls++
+		return want
+	})
+	defer api.SetReloader(nil)
+
+	request := func(method, user string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router().ServeHTTP(w, httptest.NewRequest(method, "/api/"+user+"/admin/reload", nil))
+		return w
+	}
+	decode := func(w *httptest.ResponseRecorder) (result *api.ReloadResult) {
+		t.Helper()
+		if w.Code != http.StatusOK {
+			t.Fatalf("status want: %d; got %d", http.StatusOK, w.Code)
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+			t.Fatalf("invalid response %s: %v", w.Body, err)
+		}
+		return result
+	}
+
+	if got := decode(request("POST", "testuser")); got == nil || !reflect.DeepEqual(*got, want) {
+		t.Errorf("POST want: %+v; got %+v", want, got)
+	}
+	if got := decode(request("GET", "testuser")); got == nil || !reflect.DeepEqual(*got, want) {
+		t.Errorf("GET after a reload want: %+v; got %+v", want, got)
+	}
+	if calls != 1 {
+		t.Errorf("reloader calls want: 1; got %d", calls)
+	}
+
+	if w := request("POST", "unknown"); w.Code != http.StatusBadRequest || calls != 1 {
+		t.Errorf("POST of unknown user want: %d without reload; got %d after %d reloads", http.StatusBadRequest, w.Code, calls)
+	}
+	if w := request("PUT", "testuser"); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("PUT want: %d; got %d", http.StatusMethodNotAllowed, w.Code)
+	}
+}
@@ -0,0 +1,75 @@
+package webserver
+
+import (
+	"context"
+	"encoding/json"
+	"homeserver/config"
+	"homeserver/home/driver"
+	"homeserver/webserver/api"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestHueProxy runs the hue driver against this bridge as the upstream bridge.
+func TestHueProxy(t *testing.T) {
+	store := config.NewMemoryStore()
+	defer config.SetStore(config.SetStore(store))
+	store.Save(api.USERS, "testuser", map[string]any{"username": "testuser", "devicetype": "test"})
+	store.Save(api.LIGHTS, "1", json.RawMessage(`{
+		"name": "Upstream Light",
+		"type": "Color light",
+		"uniqueid": "00:17:88:01:00:00:00:01-0b",
+		"state": {"on": false, "bri": 1, "colormode": "hs", "reachable": true}
+	}`))
+
+	srv := httptest.NewServer(router())
+	defer srv.Close()
+	ctx := context.Background()
+
+	devices, err := driver.HueLights(ctx, srv.URL, "testuser")
+	if err != nil {
+		t.Fatalf("HueLights() error = %v", err)
+	}
+	if len(devices) != 1 || devices[0].Name != "Upstream Light" || devices[0].Config["light"] != "1" {
+		t.Fatalf("HueLights() want: [Upstream Light]; got %+v", devices)
+	}
+	if _, err = driver.HueLights(ctx, srv.URL, "unknown"); err == nil {
+		t.Errorf("HueLights() with unknown user want error; got nil")
+	}
+
+	d, err := driver.New(devices[0].Config)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	want := driver.State{On: true, Brightness: 200, Hue: 10000, Saturation: 150, ColorMode: "hs"}
+	if err = d.SetState(ctx, want); err != nil {
+		t.Fatalf("SetState() error = %v", err)
+	}
+
+	l, err := api.LightFromID("1")
+	if err != nil {
+		t.Fatalf("LightFromID() error = %v", err)
+	}
+	if !l.State.On || l.State.Brightness != 200 || l.State.Hue != 10000 || l.State.Saturation != 150 {
+		t.Errorf("SetState() stored state want: %+v; got %+v", want, l.State)
+	}
+
+	got, err := d.State(ctx)
+	if err != nil {
+		t.Fatalf("State() error = %v", err)
+	}
+	if got != want {
+		t.Errorf("State() want: %+v; got %+v", want, got)
+	}
+
+	// wait for the state to be written, before restoring the store
+	for i := 0; i < 100; i++ {
+		var stored api.Light
+		if store.Load(api.LIGHTS, "1", &stored) == nil && stored.State.Brightness == want.Brightness {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("state was not written to the store")
+}
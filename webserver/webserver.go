@@ -1,37 +1,76 @@
 package webserver
 
 import (
+	"context"
 	"fmt"
 	"homeserver/config"
 	logger "log"
 	"net"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gorilla/mux"
 )
 
-var log logger.Logger = *logger.New(logger.Writer(), "[WEB] ", logger.LstdFlags|logger.Lmsgprefix)
+var log logger.Logger = *logger.New(config.LogOutput, "[WEB] ", logger.LstdFlags|logger.Lmsgprefix)
 
-func init() {
-	findAndSaveLocalIP()
+// Server is the running webserver of the bridge.
+type Server struct {
+	http   *http.Server
+	ln     net.Listener
+	cancel context.CancelFunc
+	err    chan error
 }
 
-// Run starts the webserver.
-//
-// If it fails it the first 3 seconds, Run returns an error
-func Run() error {
-	var http_err error
+// Start listens on the "listen" address and "port" and serves the bridge in the background. An
+// error binding the address is returned right away.
+func Start() (*Server, error) {
+	p := config.GetInt("port")
+	if p <= 0 {
+		return nil, fmt.Errorf("port variable is not defined")
+	}
+	ln, err := net.Listen("tcp", net.JoinHostPort(config.GetString("listen"), strconv.Itoa(p)))
+	if err != nil {
+		return nil, err
+	}
+
+	// canceled on shutdown, so long running requests like event streams end
+	base, cancel := context.WithCancel(context.Background())
+	s := &Server{
+		http: &http.Server{
+			Handler:           router(),
+			ReadHeaderTimeout: 10 * time.Second,
+			BaseContext:       func(net.Listener) context.Context { return base },
+		},
+		ln:     ln,
+		cancel: cancel,
+		err:    make(chan error, 1),
+	}
 	go func() {
-		if p := config.GetInt("port"); p > 0 {
-			http_err = http.ListenAndServe(fmt.Sprintf(":%d", p), router())
-			return
+		if err := s.http.Serve(ln); err != http.ErrServerClosed {
+			s.err <- err
 		}
-		http_err = fmt.Errorf("port variable is not defined")
+		close(s.err)
 	}()
-	// wait for an error
-	time.Sleep(time.Second * 3)
-	return http_err
+	return s, nil
+}
+
+// Addr returns the address the server is listening on.
+func (s *Server) Addr() net.Addr {
+	return s.ln.Addr()
+}
+
+// Err returns a channel receiving the error if serving fails. It is closed after Shutdown.
+func (s *Server) Err() <-chan error {
+	return s.err
+}
+
+// Shutdown stops accepting connections and waits for the running requests to finish until ctx is
+// done. Open event streams are ended.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.cancel()
+	return s.http.Shutdown(ctx)
 }
 
 func router() http.Handler {
@@ -43,6 +82,9 @@ func router() http.Handler {
 	r.HandleFunc("/description.xml", handle_discorver_xml)
 	r.HandleFunc("/api", handleApi)
 	r.HandleFunc("/api/{user}", handleUserInfo)
+	r.HandleFunc("/api/{user}/events", handleEvents)
+	r.HandleFunc("/api/{user}/admin/commands", handleCommands)
+	r.HandleFunc("/api/{user}/admin/reload", handleReload)
 	r.HandleFunc("/api/{user}/lights", handleLights)
 	r.HandleFunc("/api/{user}/lights/new", handleNewLights)
 	r.HandleFunc("/api/{user}/lights/{light}", handleLightInfo)
@@ -58,12 +100,3 @@ func logRequest(handler http.Handler) http.Handler {
 		handler.ServeHTTP(w, r)
 	})
 }
-
-func findAndSaveLocalIP() {
-	con, error := net.Dial("udp", "8.8.8.8:80")
-	if error != nil {
-		log.Fatal(error)
-	}
-	config.SetString("ip", con.LocalAddr().(*net.UDPAddr).IP.String())
-	con.Close()
-}
@@ -0,0 +1,69 @@
+package webserver
+
+import (
+	"bufio"
+	"context"
+	"homeserver/config"
+	"homeserver/webserver/api"
+	"net"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestStartShutdown(t *testing.T) {
+	store := config.NewMemoryStore()
+	defer config.SetStore(config.SetStore(store))
+	store.Save(api.USERS, "testuser", map[string]any{"username": "testuser", "devicetype": "test"})
+
+	// find a free port
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	config.SetString("listen", "127.0.0.1")
+	config.SetInt("port", port)
+
+	if _, err = Start(); err == nil {
+		t.Fatalf("Start() on a used port want error; got nil")
+	}
+	ln.Close()
+
+	s, err := Start()
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	resp, err := http.Get("http://127.0.0.1:" + strconv.Itoa(port) + "/api/testuser/events")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	defer resp.Body.Close()
+
+	ended := make(chan bool)
+	go func() {
+		// the stream ends on shutdown
+		r := bufio.NewReader(resp.Body)
+		for {
+			if _, err := r.ReadString('\n'); err != nil {
+				close(ended)
+				return
+			}
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err = s.Shutdown(ctx); err != nil {
+		t.Errorf("Shutdown() error = %v", err)
+	}
+	select {
+	case <-ended:
+	case <-time.After(time.Second):
+		t.Errorf("Shutdown() did not end the event stream")
+	}
+	if err, ok := <-s.Err(); ok {
+		t.Errorf("Err() want closed; got %v", err)
+	}
+}